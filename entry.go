@@ -0,0 +1,54 @@
+package shardedmap
+
+// StrEntry is a key and value pair of a StrMap. It's the element type of every
+// method returning entries, rather than passing them to a callback, such as
+// Items, Page, ScanBatches and GroupByShard, so that their results compose.
+// Uint64Entry and UUIDEntry are the same for the other maps.
+type StrEntry struct {
+	Key   string
+	Value interface{}
+}
+
+// Uint64Entry is a key and value pair of a Uint64Map.
+type Uint64Entry struct {
+	Key   uint64
+	Value interface{}
+}
+
+// UUIDEntry is a key and value pair of a UUIDMap.
+type UUIDEntry struct {
+	Key   UUID
+	Value interface{}
+}
+
+// Cursor is an opaque position in a map, as returned by Page. The zero Cursor
+// is the start of the map. A Cursor is only valid for the map that returned it.
+type Cursor struct {
+	shard   int
+	after   interface{} // Last key returned from shard, if started
+	started bool
+}
+
+// RangePanic is a panic recovered by RangeSafe while visiting Key, which holds
+// the string, uint64 or UUID key of the map, with the value passed to panic.
+type RangePanic struct {
+	Key       interface{}
+	Recovered interface{}
+}
+
+// callSafe calls f, recovering any panic into p, in which case the range goes
+// on.
+func callSafe[K any](f func(key K, value interface{}) bool, key K, value interface{}) (ok bool, p *RangePanic) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok, p = true, &RangePanic{Key: key, Recovered: r}
+		}
+	}()
+	return f(key, value), nil
+}
+
+// result is a value along with the error of computing it, see StoreResult.
+type result struct {
+	value interface{}
+	err   error
+}
@@ -0,0 +1,24 @@
+package shardedmap
+
+import (
+	"errors"
+)
+
+// ErrLengthMismatch is returned when parallel key and value slices don't have
+// the same length.
+var ErrLengthMismatch = errors.New("shardedmap: keys and values lengths differ")
+
+// ErrCrossShard is returned by WithShardLock when the keys don't all belong to
+// the same shard.
+var ErrCrossShard = errors.New("shardedmap: keys belong to different shards")
+
+// ErrLockTimeout is returned when a shard lock can't be taken within the
+// duration set WithLockTimeout.
+var ErrLockTimeout = errors.New("shardedmap: timed out waiting for the shard lock")
+
+// ErrNoSuchShard is returned when a shard index is out of range.
+var ErrNoSuchShard = errors.New("shardedmap: shard index out of range")
+
+// ErrZeroKey is returned by UUIDMap.WaitLoad for the zero UUID when the map
+// was created with RejectZeroKey, as it could never be stored.
+var ErrZeroKey = errors.New("shardedmap: zero UUID rejected")
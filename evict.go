@@ -0,0 +1,35 @@
+package shardedmap
+
+// EvictReason tells why an entry was removed or replaced, as passed to the
+// eviction callbacks (see WithStrOnEvict).
+type EvictReason int
+
+const (
+	// EvictOverwrite is for values replaced by a new one, as by Store.
+	EvictOverwrite EvictReason = iota
+	// EvictDelete is for entries removed, as by Delete.
+	EvictDelete
+	// EvictClear is for entries removed by ClearWithEvict.
+	EvictClear
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictOverwrite:
+		return "overwrite"
+	case EvictDelete:
+		return "delete"
+	case EvictClear:
+		return "clear"
+	default:
+		return "unknown"
+	}
+}
+
+// eviction is an entry removed or replaced while a shard was locked, so that
+// the eviction callback can be called once it's unlocked.
+type eviction[K any] struct {
+	key    K
+	value  interface{}
+	reason EvictReason
+}
@@ -0,0 +1,3 @@
+module github.com/antoniomo/shardedmap
+
+go 1.24
@@ -0,0 +1,190 @@
+package shardedmap
+
+import (
+	"container/list"
+	"sync/atomic"
+)
+
+// LRUStrMap is a StrMap bounded to a maximum number of entries, evicting the
+// least recently used ones when full. Each shard keeps its own LRU list and
+// holds up to maxEntries/shardCount entries, so it only approximates a global
+// LRU: an entry may be evicted from a full shard while older ones live in
+// others. In exchange, there's no global lock to contend.
+//
+// WithSizeOf and WithMaxBytes also bound the total size of the values, split
+// among the shards the same way, for values of wildly different sizes.
+//
+// Since Load updates the recency of entries, it takes the shard write lock.
+type LRUStrMap struct {
+	shardCount uint64 // Don't alter after creation, no mutex here
+	shardCap   int
+	shardBytes int64 // Per shard budget, 0 if unbounded
+	bytes      int64 // Size of all values WithSizeOf, use atomics
+	mutexes    []shardMutex
+	shards     []lruShard
+	onEvict    func(key string, value interface{})
+	opts       options
+}
+
+type lruShard struct {
+	items map[string]*list.Element
+	order list.List // Most recently used first
+	bytes int64     // Size of its values WithSizeOf
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+	size  int64
+}
+
+// NewLRUStrMap creates a map holding up to maxEntries entries, rounded down to
+// a multiple of the shard count, but at least one per shard. onEvict, if not
+// nil, is called with every entry evicted to make room, outside of any lock.
+func NewLRUStrMap(shardCount, maxEntries int, onEvict func(key string, value interface{}), opts ...Option) *LRUStrMap {
+	o := newOptions(opts)
+	shardCount = o.shards(shardCount)
+	shardCap := maxEntries / shardCount
+	if shardCap < 1 {
+		shardCap = 1
+	}
+
+	var shardBytes int64
+	if o.maxBytes > 0 && o.sizeOf != nil {
+		if shardBytes = o.maxBytes / int64(shardCount); shardBytes < 1 {
+			shardBytes = 1
+		}
+	}
+
+	sm := &LRUStrMap{
+		shardCount: uint64(shardCount),
+		shardCap:   shardCap,
+		shardBytes: shardBytes,
+		mutexes:    newShardMutexes(shardCount, o),
+		shards:     make([]lruShard, shardCount),
+		onEvict:    onEvict,
+		opts:       o,
+	}
+
+	for i := range sm.shards {
+		sm.shards[i].items = make(map[string]*list.Element)
+	}
+
+	return sm
+}
+
+func (sm *LRUStrMap) pickShard(key string) uint64 {
+	if sm.opts.strShardFunc != nil {
+		return sm.opts.strShardFunc(key) % sm.shardCount
+	}
+	return sm.opts.shardHashString(key) % sm.shardCount
+}
+
+// addBytes adds n to the value sizes of shard s, which must be write locked by
+// the caller, and of the map.
+func (sm *LRUStrMap) addBytes(s *lruShard, n int64) {
+	if n != 0 {
+		s.bytes += n
+		atomic.AddInt64(&sm.bytes, n)
+	}
+}
+
+// Store stores value under key as the most recently used entry, evicting the
+// least recently used ones of its shard if that's full, or over its byte
+// budget WithMaxBytes. The entry just stored is never evicted, so a value
+// larger than the budget of a shard is left alone in it. The size of value is
+// measured before locking.
+func (sm *LRUStrMap) Store(key string, value interface{}) {
+	var size int64
+	if sm.opts.sizeOf != nil {
+		size = int64(sm.opts.sizeOf(value))
+	}
+	shard := sm.pickShard(key)
+	s := &sm.shards[shard]
+	sm.mutexes[shard].Lock()
+	if elem, ok := s.items[key]; ok {
+		entry := elem.Value.(*lruEntry)
+		sm.addBytes(s, size-entry.size)
+		entry.value, entry.size = value, size
+		s.order.MoveToFront(elem)
+	} else {
+		s.items[key] = s.order.PushFront(&lruEntry{key: key, value: value, size: size})
+		sm.addBytes(s, size)
+	}
+	var evicted []*lruEntry
+	for s.order.Len() > sm.shardCap || sm.shardBytes > 0 && s.bytes > sm.shardBytes && s.order.Len() > 1 {
+		entry := s.order.Remove(s.order.Back()).(*lruEntry)
+		delete(s.items, entry.key)
+		sm.addBytes(s, -entry.size)
+		evicted = append(evicted, entry)
+	}
+	sm.mutexes[shard].Unlock()
+	if sm.onEvict != nil {
+		for _, entry := range evicted {
+			sm.onEvict(entry.key, entry.value)
+		}
+	}
+}
+
+// Load returns the value under key, making it the most recently used entry.
+func (sm *LRUStrMap) Load(key string) (interface{}, bool) {
+	shard := sm.pickShard(key)
+	s := &sm.shards[shard]
+	sm.mutexes[shard].Lock()
+	elem, ok := s.items[key]
+	if !ok {
+		sm.mutexes[shard].Unlock()
+		return nil, false
+	}
+	s.order.MoveToFront(elem)
+	value := elem.Value.(*lruEntry).value
+	sm.mutexes[shard].Unlock()
+	return value, true
+}
+
+// Delete removes key, without calling the eviction callback.
+func (sm *LRUStrMap) Delete(key string) {
+	shard := sm.pickShard(key)
+	s := &sm.shards[shard]
+	sm.mutexes[shard].Lock()
+	if elem, ok := s.items[key]; ok {
+		s.order.Remove(elem)
+		delete(s.items, key)
+		sm.addBytes(s, -elem.Value.(*lruEntry).size)
+	}
+	sm.mutexes[shard].Unlock()
+}
+
+// Len returns the number of entries, adding up the shard sizes under their
+// lock.
+func (sm *LRUStrMap) Len() int {
+	var n int
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		n += len(sm.shards[shard].items)
+		sm.mutexes[shard].RUnlock()
+	}
+	return n
+}
+
+// Range is like StrMap.Range, visiting each shard from its most to its least
+// recently used entry, without updating their recency.
+func (sm *LRUStrMap) Range(f func(key string, value interface{}) bool) {
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		for elem := sm.shards[shard].order.Front(); elem != nil; elem = elem.Next() {
+			entry := elem.Value.(*lruEntry)
+			if !f(entry.key, entry.value) {
+				sm.mutexes[shard].RUnlock()
+				return
+			}
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+}
+
+// Bytes returns the total size of the values, as measured WithSizeOf, or 0
+// without it. It's kept up to date on every write, so it's O(1).
+func (sm *LRUStrMap) Bytes() int64 {
+	return atomic.LoadInt64(&sm.bytes)
+}
@@ -0,0 +1,103 @@
+package shardedmap
+
+import (
+	"slices"
+	"testing"
+)
+
+// lruKeys returns the keys of sm from the most to the least recently used.
+func lruKeys(sm *LRUStrMap) []string {
+	var keys []string
+	sm.Range(func(key string, _ interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func TestLRUStrMapEvictionOrder(t *testing.T) {
+	var evicted []string
+	sm := NewLRUStrMap(1, 3, func(key string, value interface{}) {
+		if value != key+"v" {
+			t.Errorf("evicted %q with value %v", key, value)
+		}
+		evicted = append(evicted, key)
+	})
+	for _, key := range []string{"a", "b", "c"} {
+		sm.Store(key, key+"v")
+	}
+	if len(evicted) != 0 {
+		t.Fatalf("evicted %v before filling up", evicted)
+	}
+
+	// Loading and storing again both make an entry the most recently used.
+	sm.Load("a")
+	sm.Store("b", "bv")
+	if got, want := lruKeys(sm), []string{"b", "a", "c"}; !slices.Equal(got, want) {
+		t.Fatalf("recency order = %v, want %v", got, want)
+	}
+
+	sm.Store("d", "dv")
+	sm.Store("e", "ev")
+	if want := []string{"c", "a"}; !slices.Equal(evicted, want) {
+		t.Errorf("evicted %v, want %v", evicted, want)
+	}
+	if got, want := lruKeys(sm), []string{"e", "d", "b"}; !slices.Equal(got, want) {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+	if _, ok := sm.Load("a"); ok {
+		t.Error("evicted entry still loads")
+	}
+
+	// Deletes make room without going through the callback.
+	sm.Delete("d")
+	sm.Store("f", "fv")
+	if len(evicted) != 2 || sm.Len() != 3 {
+		t.Errorf("after Delete evicted %v, Len %d", evicted, sm.Len())
+	}
+}
+
+func TestLRUStrMapByteBudget(t *testing.T) {
+	var evicted []string
+	sm := NewLRUStrMap(1, 100, func(key string, _ interface{}) {
+		evicted = append(evicted, key)
+	}, WithSizeOf(func(value interface{}) int {
+		return len(value.(string))
+	}), WithMaxBytes(10))
+
+	sm.Store("a", "1234")
+	sm.Store("b", "1234")
+	if got := sm.Bytes(); got != 8 {
+		t.Fatalf("Bytes = %d, want 8", got)
+	}
+	// Overwrites account for the size difference.
+	sm.Store("a", "12")
+	if got := sm.Bytes(); got != 6 {
+		t.Fatalf("Bytes after overwrite = %d, want 6", got)
+	}
+
+	sm.Store("c", "123456")
+	if want := []string{"b"}; !slices.Equal(evicted, want) {
+		t.Errorf("evicted %v, want %v", evicted, want)
+	}
+	if got := sm.Bytes(); got != 8 {
+		t.Errorf("Bytes after eviction = %d, want 8", got)
+	}
+
+	// A value over the whole budget evicts everything else, but stays.
+	sm.Store("d", "123456789012")
+	if want := []string{"b", "a", "c"}; !slices.Equal(evicted, want) {
+		t.Errorf("evicted %v, want %v", evicted, want)
+	}
+	if got := lruKeys(sm); !slices.Equal(got, []string{"d"}) {
+		t.Errorf("entries = %v, want [d]", got)
+	}
+	if got := sm.Bytes(); got != 12 {
+		t.Errorf("Bytes = %d, want 12", got)
+	}
+
+	sm.Delete("d")
+	if got := sm.Bytes(); got != 0 {
+		t.Errorf("Bytes after Delete = %d, want 0", got)
+	}
+}
@@ -0,0 +1,62 @@
+package shardedmap
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+//nolint:gochecknoglobals
+var lastMapID uint64 // See nextMapID
+
+// nextMapID returns a new map identity, ordering maps by creation to give
+// MultiLock a global lock order.
+func nextMapID() uint64 {
+	return atomic.AddUint64(&lastMapID, 1)
+}
+
+// ShardToken identifies the shard of a map that holds a given key, as returned
+// by StrMap.ShardToken and alike. Tokens of the StrMap, Uint64Map and UUIDMap
+// share a single total order, by map creation and then by shard, which
+// MultiLock follows to lock shards of several maps without deadlocks.
+type ShardToken struct {
+	mapID uint64
+	shard uint64
+	mu    *shardMutex
+}
+
+func (t ShardToken) less(u ShardToken) bool {
+	if t.mapID != u.mapID {
+		return t.mapID < u.mapID
+	}
+	return t.shard < u.shard
+}
+
+// MultiLock write locks the shards of tokens, which may belong to different
+// maps, to update related keys across them atomically, say an index and the
+// primary map it points into. Shards are locked in the global token order, and
+// each only once even if several tokens name it, so concurrent MultiLock calls
+// can't deadlock each other. The returned unlock releases them all, and must be
+// called exactly once.
+//
+// While holding the locks, only use the LoadLocked, StoreLocked and
+// DeleteLocked methods, and only with keys whose token was passed to
+// MultiLock: any other method of a locked map might deadlock. Holding the
+// locks of a map while calling its other methods, or while locking it by other
+// means, breaks the order.
+func MultiLock(tokens ...ShardToken) (unlock func()) {
+	sorted := append([]ShardToken(nil), tokens...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].less(sorted[j]) })
+	locked := sorted[:0]
+	for i, t := range sorted {
+		if i > 0 && t == sorted[i-1] {
+			continue
+		}
+		t.mu.Lock()
+		locked = append(locked, t)
+	}
+	return func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			locked[i].mu.Unlock()
+		}
+	}
+}
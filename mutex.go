@@ -0,0 +1,108 @@
+package shardedmap
+
+import (
+	"sync"
+	"time"
+)
+
+// shardMutex is the lock of a shard. It's an RWMutex, unless the map was
+// created WithMutexType(MutexPlain) (or WithFairLocking), in which case every
+// operation, reads included, takes a plain Mutex instead. A flag checked on
+// each call is cheaper than an interface, whose calls can't be inlined.
+type shardMutex struct {
+	rw    sync.RWMutex
+	mu    sync.Mutex
+	plain bool // Don't alter after creation
+}
+
+func newShardMutexes(shardCount int, o options) []shardMutex {
+	mutexes := make([]shardMutex, shardCount)
+	for i := range mutexes {
+		mutexes[i].plain = o.mutexType == MutexPlain
+	}
+	return mutexes
+}
+
+func (m *shardMutex) Lock() {
+	if m.plain {
+		m.mu.Lock()
+		return
+	}
+	m.rw.Lock()
+}
+
+func (m *shardMutex) Unlock() {
+	if m.plain {
+		m.mu.Unlock()
+		return
+	}
+	m.rw.Unlock()
+}
+
+func (m *shardMutex) RLock() {
+	if m.plain {
+		m.mu.Lock()
+		return
+	}
+	m.rw.RLock()
+}
+
+func (m *shardMutex) RUnlock() {
+	if m.plain {
+		m.mu.Unlock()
+		return
+	}
+	m.rw.RUnlock()
+}
+
+func (m *shardMutex) TryLock() bool {
+	if m.plain {
+		return m.mu.TryLock()
+	}
+	return m.rw.TryLock()
+}
+
+func (m *shardMutex) TryRLock() bool {
+	if m.plain {
+		return m.mu.TryLock()
+	}
+	return m.rw.TryRLock()
+}
+
+// Backoff bounds between lock attempts of lockTimeout.
+const (
+	minLockBackoff = time.Microsecond
+	maxLockBackoff = time.Millisecond
+)
+
+// lockTimeout tries to take the lock, for reading if read is true, for up to
+// d, sleeping with exponential backoff between attempts. It reports whether it
+// got the lock. If d <= 0 it just waits for the lock.
+func (m *shardMutex) lockTimeout(d time.Duration, read bool) bool {
+	if d <= 0 {
+		if read {
+			m.RLock()
+		} else {
+			m.Lock()
+		}
+		return true
+	}
+	deadline := time.Now().Add(d)
+	backoff := minLockBackoff
+	for {
+		if read && m.TryRLock() || !read && m.TryLock() {
+			return true
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			return false
+		}
+		if backoff > left {
+			backoff = left
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxLockBackoff {
+			backoff = maxLockBackoff
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package shardedmap
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkMutexType runs a parallel mix of Load and Store with each kind of
+// shard lock, over several write ratios. Whether MutexRW wins depends on the
+// cores available for readers to overlap, so compare with several -cpu values.
+func BenchmarkMutexType(b *testing.B) {
+	const keyCount = 1024
+	keys := make([]string, keyCount)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	for _, mt := range []struct {
+		name string
+		t    MutexType
+	}{{"rw", MutexRW}, {"plain", MutexPlain}} {
+		for _, writes := range []int{0, 10, 50, 100} {
+			b.Run(fmt.Sprintf("%s/writes=%d%%", mt.name, writes), func(b *testing.B) {
+				sm := NewStrMap(64, WithMutexType(mt.t))
+				for _, key := range keys {
+					sm.Store(key, key)
+				}
+				var seed uint64
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					i := int(atomic.AddUint64(&seed, 7919))
+					for pb.Next() {
+						key := keys[i%keyCount]
+						if i%100 < writes {
+							sm.Store(key, key)
+						} else {
+							sm.Load(key)
+						}
+						i++
+					}
+				})
+			})
+		}
+	}
+}
+
+// BenchmarkFairLockingWriterLatency measures how long a Store takes to go
+// through while readers keep a single shard busy, with and without
+// WithFairLocking, reporting the worst case too, as starved writers show up
+// in the tail rather than in the mean.
+func BenchmarkFairLockingWriterLatency(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		opts []Option
+	}{{"rw", nil}, {"fair", []Option{WithFairLocking()}}} {
+		b.Run(bm.name, func(b *testing.B) {
+			sm := NewStrMap(1, bm.opts...)
+			sm.Store("key", 0)
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			for i := 0; i < 4*runtime.GOMAXPROCS(0); i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+							sm.Load("key")
+						}
+					}
+				}()
+			}
+			var worst time.Duration
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				sm.Store("key", i)
+				if d := time.Since(start); d > worst {
+					worst = d
+				}
+			}
+			b.StopTimer()
+			close(stop)
+			wg.Wait()
+			b.ReportMetric(float64(worst.Nanoseconds()), "max-ns")
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package shardedmap
+
+import (
+	"hash/maphash"
+)
+
+// Number is the constraint of NumericMap values.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// NumericMap is a sharded map of numbers, stored unboxed, for type safe and
+// allocation free counters or accumulators of any key type.
+type NumericMap[K comparable, V Number] struct {
+	shardCount uint64 // Don't alter after creation, no mutex here
+	seed       maphash.Seed
+	mutexes    []shardMutex
+	maps       []map[K]V
+}
+
+// NewNumericMap ...
+func NewNumericMap[K comparable, V Number](shardCount int, opts ...Option) *NumericMap[K, V] {
+	o := newOptions(opts)
+	shardCount = o.shards(shardCount)
+
+	sm := &NumericMap[K, V]{
+		shardCount: uint64(shardCount),
+		seed:       maphash.MakeSeed(),
+		mutexes:    newShardMutexes(shardCount, o),
+		maps:       make([]map[K]V, shardCount),
+	}
+
+	for i := range sm.maps {
+		sm.maps[i] = make(map[K]V)
+	}
+
+	return sm
+}
+
+// pickShard hashes keys of any comparable type with maphash.Comparable, which
+// is what sets the go.mod requirement of Go 1.24.
+func (sm *NumericMap[K, V]) pickShard(key K) uint64 {
+	return maphash.Comparable(sm.seed, key) % sm.shardCount
+}
+
+// Store ...
+func (sm *NumericMap[K, V]) Store(key K, value V) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].Lock()
+	sm.maps[shard][key] = value
+	sm.mutexes[shard].Unlock()
+}
+
+// Load ...
+func (sm *NumericMap[K, V]) Load(key K) (V, bool) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].RLock()
+	value, ok := sm.maps[shard][key]
+	sm.mutexes[shard].RUnlock()
+	return value, ok
+}
+
+// Add atomically adds delta to the value under key, which starts at zero if
+// absent, and returns the new total.
+func (sm *NumericMap[K, V]) Add(key K, delta V) V {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].Lock()
+	value := sm.maps[shard][key] + delta
+	sm.maps[shard][key] = value
+	sm.mutexes[shard].Unlock()
+	return value
+}
+
+// Delete ...
+func (sm *NumericMap[K, V]) Delete(key K) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].Lock()
+	delete(sm.maps[shard], key)
+	sm.mutexes[shard].Unlock()
+}
+
+// Range is like StrMap.Range.
+func (sm *NumericMap[K, V]) Range(f func(key K, value V) bool) {
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		for key, value := range sm.maps[shard] {
+			if !f(key, value) {
+				sm.mutexes[shard].RUnlock()
+				return
+			}
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+}
+
+// IncUpTo atomically increments the value under key by one, starting at zero if
+// absent, as long as it's below max. It returns the value left
+// under key and whether it was incremented, which makes for simple per key rate
+// limiting: allowed is false once max is reached.
+func (sm *NumericMap[K, V]) IncUpTo(key K, max V) (value V, allowed bool) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].Lock()
+	value = sm.maps[shard][key]
+	if value < max {
+		value++
+		sm.maps[shard][key] = value
+		allowed = true
+	}
+	sm.mutexes[shard].Unlock()
+	return value, allowed
+}
+
+// Compute atomically reads and replaces the value under key, like
+// StrMap.Update: f gets the current value, zero if absent, and whether there
+// was one, and returns the new value and whether to keep it: if keep is false
+// the key is deleted instead. f is called under the shard write lock, so keep
+// it short and don't use the map from it. Compute returns the value left under
+// key and whether there's any. For plain additions, Add is simpler.
+func (sm *NumericMap[K, V]) Compute(key K, f func(old V, loaded bool) (newValue V, keep bool)) (value V, ok bool) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].Lock()
+	old, loaded := sm.maps[shard][key]
+	value, ok = f(old, loaded)
+	if ok {
+		sm.maps[shard][key] = value
+	} else {
+		var zero V
+		value = zero
+		delete(sm.maps[shard], key)
+	}
+	sm.mutexes[shard].Unlock()
+	return value, ok
+}
+
+// AddCounts adds each value of m to the one under its key, which starts at
+// zero if absent, as in the combine step of a word count. It takes each shard
+// write lock once for all its keys, rather than once per key as calling Add
+// for each would, after sorting the keys by shard, which takes two
+// allocations per call whatever the size of m. BenchmarkNumericMapAddCounts
+// compares it with a loop of Add. Every single addition is atomic, but the
+// batch as a whole isn't: shards are updated one after another.
+func (sm *NumericMap[K, V]) AddCounts(m map[K]V) {
+	n := len(m)
+	if n == 0 {
+		return
+	}
+	// Counting sort by shard, in a single buffer: the entries go in its first
+	// half as they come, and then in its second half by shard. offsets holds
+	// the entry count of each shard, then where its run starts, and, once
+	// filled, where it ends.
+	buf := make([]shardCount[K, V], 2*n)
+	unsorted, sorted := buf[:n], buf[n:]
+	offsets := make([]int, sm.shardCount)
+	i := 0
+	for key, delta := range m {
+		shard := sm.pickShard(key)
+		unsorted[i] = shardCount[K, V]{key, delta, shard}
+		offsets[shard]++
+		i++
+	}
+	start := 0
+	for shard, count := range offsets {
+		offsets[shard] = start
+		start += count
+	}
+	for _, c := range unsorted {
+		sorted[offsets[c.shard]] = c
+		offsets[c.shard]++
+	}
+	from := 0
+	for shard, to := range offsets {
+		if from == to {
+			continue
+		}
+		sm.mutexes[shard].Lock()
+		for _, c := range sorted[from:to] {
+			sm.maps[shard][c.key] += c.delta
+		}
+		sm.mutexes[shard].Unlock()
+		from = to
+	}
+}
+
+// shardCount is a count to add with AddCounts, along with its shard.
+type shardCount[K comparable, V Number] struct {
+	key   K
+	delta V
+	shard uint64
+}
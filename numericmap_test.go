@@ -0,0 +1,162 @@
+package shardedmap
+
+import (
+	"maps"
+	"strconv"
+	"testing"
+)
+
+func TestNumericMapIncUpTo(t *testing.T) {
+	const max = 3
+	tests := []struct {
+		name        string
+		start       int64
+		present     bool
+		wantValue   int64
+		wantAllowed bool
+	}{
+		{name: "absent", wantValue: 1, wantAllowed: true},
+		{name: "below max", start: max - 1, present: true, wantValue: max, wantAllowed: true},
+		{name: "at max", start: max, present: true, wantValue: max},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := NewNumericMap[string, int64](4)
+			if tt.present {
+				sm.Store("key", tt.start)
+			}
+			value, allowed := sm.IncUpTo("key", max)
+			if value != tt.wantValue || allowed != tt.wantAllowed {
+				t.Errorf("IncUpTo = (%d, %v), want (%d, %v)", value, allowed, tt.wantValue, tt.wantAllowed)
+			}
+			if got, _ := sm.Load("key"); got != tt.wantValue {
+				t.Errorf("Load after IncUpTo = %d, want %d", got, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestNumericMapCompute(t *testing.T) {
+	sm := NewNumericMap[string, int](4)
+	double := func(old int, loaded bool) (int, bool) {
+		if !loaded {
+			return 1, true
+		}
+		return old * 2, true
+	}
+
+	if value, ok := sm.Compute("key", double); value != 1 || !ok {
+		t.Errorf("insert = (%d, %v), want (1, true)", value, ok)
+	}
+	if value, ok := sm.Compute("key", double); value != 2 || !ok {
+		t.Errorf("update = (%d, %v), want (2, true)", value, ok)
+	}
+	if got, _ := sm.Load("key"); got != 2 {
+		t.Errorf("Load after update = %d, want 2", got)
+	}
+
+	value, ok := sm.Compute("key", func(old int, loaded bool) (int, bool) {
+		if old != 2 || !loaded {
+			t.Errorf("delete got (%d, %v), want (2, true)", old, loaded)
+		}
+		return old, false
+	})
+	if value != 0 || ok {
+		t.Errorf("delete = (%d, %v), want (0, false)", value, ok)
+	}
+	if _, ok := sm.Load("key"); ok {
+		t.Error("key still present after delete")
+	}
+}
+
+// BenchmarkNumericMapAdd compares NumericMap.Add with the equivalent counter
+// built on StrMap.Update, which boxes every new total.
+func BenchmarkNumericMapAdd(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	b.Run("numeric", func(b *testing.B) {
+		sm := NewNumericMap[string, int64](64)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sm.Add(keys[i%len(keys)], 1<<20)
+		}
+	})
+	b.Run("interface", func(b *testing.B) {
+		sm := NewStrMap(64)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sm.Update(keys[i%len(keys)], func(value interface{}, loaded bool) (interface{}, bool) {
+				n, _ := value.(int64)
+				return n + 1<<20, true
+			})
+		}
+	})
+}
+
+// BenchmarkNumericMapAddCounts compares merging a batch of 10k counts with
+// AddCounts, one lock per shard, against calling Add for each, one lock per
+// key, from a single goroutine and from several at once.
+func BenchmarkNumericMapAddCounts(b *testing.B) {
+	counts := make(map[string]int64, 10000)
+	for i := 0; i < 10000; i++ {
+		counts[strconv.Itoa(i)] = int64(i)
+	}
+	addEach := func(sm *NumericMap[string, int64]) {
+		for key, n := range counts {
+			sm.Add(key, n)
+		}
+	}
+	for _, bm := range []struct {
+		name string
+		add  func(sm *NumericMap[string, int64])
+	}{
+		{"AddCounts", func(sm *NumericMap[string, int64]) { sm.AddCounts(counts) }},
+		{"Add", addEach},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			sm := NewNumericMap[string, int64](64)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				bm.add(sm)
+			}
+		})
+		b.Run(bm.name+"/parallel", func(b *testing.B) {
+			sm := NewNumericMap[string, int64](64)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					bm.add(sm)
+				}
+			})
+		})
+	}
+}
+
+func TestNumericMapAddCounts(t *testing.T) {
+	sm := NewNumericMap[string, int](8)
+	want := map[string]int{}
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		sm.Store(key, i)
+		want[key] = i
+	}
+	counts := map[string]int{}
+	for i := 50; i < 150; i++ {
+		key := strconv.Itoa(i)
+		counts[key] = 2
+		want[key] += 2
+	}
+	sm.AddCounts(counts)
+	sm.AddCounts(nil)
+
+	got := map[string]int{}
+	sm.Range(func(key string, value int) bool {
+		got[key] = value
+		return true
+	})
+	if !maps.Equal(got, want) {
+		t.Errorf("after AddCounts got %v, want %v", got, want)
+	}
+}
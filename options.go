@@ -0,0 +1,503 @@
+package shardedmap
+
+import (
+	"encoding/binary"
+	"math"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Option configures a map upon creation. Options that only make sense for a
+// given map type are documented as such, and ignored by the other ones.
+type Option func(*options)
+
+type options struct {
+	rejectZeroKey   bool
+	countLen        bool
+	seeded          bool
+	seed            uint64
+	shrinkThreshold float64
+	strShardFunc    func(key string) uint64
+	uint64ShardFunc func(key uint64) uint64
+	uuidShardFunc   func(key UUID) uint64
+	mutexType       MutexType
+	bytesPerEntry   int
+	sizeOf          func(value interface{}) int
+	maxShards       int
+	shardsPerCPU    int
+	lockTimeout     time.Duration
+	deterministic   bool
+	strOnEvict      func(key string, value interface{}, reason EvictReason)
+	uint64OnEvict   func(key uint64, value interface{}, reason EvictReason)
+	uuidOnEvict     func(key UUID, value interface{}, reason EvictReason)
+	cowShards       bool
+	expectedSize    int
+	loadFactor      float64
+	maxBytes        int64
+	prefixShard     bool
+	prefixSep       byte
+	shardSizeLimit  int
+	onShardSize     func(shard, size int)
+	rangeRefresh    time.Duration
+	noReadFastPath  bool
+	maxParallelism  int
+}
+
+// DefaultMaxShards is the maximum number of shards a map can have, unless
+// raised WithMaxShards. Larger shard counts are clamped to it, so that a
+// misconfigured count doesn't allocate a huge amount of tiny maps.
+const DefaultMaxShards = 1 << 16
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// RejectZeroKey makes a UUIDMap ignore the zero UUID: Store and Delete become
+// no-ops for it, LoadOrStore returns the value it's given without storing it,
+// and Load always misses. The zero UUID usually means "unset", so this guards
+// against uninitialized IDs silently sharing the same entry. By default the
+// zero UUID is a key like any other.
+//
+// Only UUIDMap honours this option.
+func RejectZeroKey() Option {
+	return func(o *options) {
+		o.rejectZeroKey = true
+	}
+}
+
+// WithLenCounter keeps a running count of the entries in the map, updated
+// atomically on every insertion and deletion, so that LenApprox is O(1). Maps
+// that don't need it avoid the extra lookup and atomic operation on writes.
+func WithLenCounter() Option {
+	return func(o *options) {
+		o.countLen = true
+	}
+}
+
+// WithRandomSeed mixes a random per-map seed into the hash used to pick the
+// shard of each key. If keys come from untrusted input (user supplied strings,
+// IDs...), this keeps an attacker from crafting keys that all land on the same
+// shard to contend its lock. The tradeoff is that key placement differs for
+// every map, so two maps with the same shard count no longer agree on it.
+//
+// For Uint64Map, which by default just uses the key modulo the shard count,
+// this also switches to hashing the keys.
+func WithRandomSeed() Option {
+	return func(o *options) {
+		o.seeded = true
+		o.seed = randomSeed()
+	}
+}
+
+// WithSeed is like WithRandomSeed, but with a fixed seed, so that tests can
+// reproduce key placement. Note that the underlying runtime hash is itself
+// seeded per process, so placement is still only stable within a process,
+// unless combined WithDeterministicHash. Uint64Map switches to hashing the keys
+// with this option too.
+func WithSeed(seed uint64) Option {
+	return func(o *options) {
+		o.seeded = true
+		o.seed = seed
+	}
+}
+
+// WithShrinkThreshold makes Delete compact a shard, rehashing it into a fresh
+// map, once the deletions since its last compaction exceed fraction times its
+// live entries. Go maps keep their memory after deletes, so this bounds memory
+// on delete heavy workloads without calling Compact by hand. As each compaction
+// copies the live entries, their cost amortizes to O(1/fraction) per delete,
+// paid by the Delete that triggered it while holding the shard write lock.
+// Values <= 0 disable it, which is the default.
+func WithShrinkThreshold(fraction float64) Option {
+	return func(o *options) {
+		o.shrinkThreshold = fraction
+	}
+}
+
+// WithStrShardFunc replaces the hash used to pick the shard of each key with f,
+// whose result is taken modulo the shard count. It lets keys that are often
+// used together (say, those of the same tenant) share a shard. Note that a bad
+// shard function can ruin the key distribution, and with it the point of
+// sharding.
+//
+// Only StrMap and StrSet honour this option.
+func WithStrShardFunc(f func(key string) uint64) Option {
+	return func(o *options) {
+		o.strShardFunc = f
+	}
+}
+
+// WithStringPrefixShard picks the shard of each string key by hashing only its
+// prefix before the first sep, or the whole key if there's none, so that keys
+// like "tenant:resource" with ':' share the shard of their tenant, for
+// locality and cheap per tenant scans (see StoreShard and RLockShard). Shards
+// become as uneven as the tenants are, so a big tenant makes a hot shard.
+// WithStrShardFunc takes precedence over it.
+//
+// Only StrMap, StrSet, LRUStrMap and TimestampedStrMap honour this option.
+func WithStringPrefixShard(sep byte) Option {
+	return func(o *options) {
+		o.prefixShard = true
+		o.prefixSep = sep
+	}
+}
+
+// WithUint64ShardFunc is WithStrShardFunc for Uint64Map and Uint64Set.
+func WithUint64ShardFunc(f func(key uint64) uint64) Option {
+	return func(o *options) {
+		o.uint64ShardFunc = f
+	}
+}
+
+// WithUUIDShardFunc is WithStrShardFunc for UUIDMap and UUIDSet.
+func WithUUIDShardFunc(f func(key UUID) uint64) Option {
+	return func(o *options) {
+		o.uuidShardFunc = f
+	}
+}
+
+// WithFairLocking makes every shard operation, reads included, take a plain
+// Mutex instead of an RWMutex. Under a sustained read load RWMutex writers can
+// wait for long, while Mutex switches to FIFO handoff once a waiter has been
+// blocked for over 1ms, so rare writes (say, refreshing a cache) don't stall.
+// The price is that readers of the same shard no longer run in parallel.
+// BenchmarkFairLockingWriterLatency measures Store under such a load with
+// both locks, worst case included.
+//
+// It's the same as WithMutexType(MutexPlain).
+func WithFairLocking() Option {
+	return WithMutexType(MutexPlain)
+}
+
+// MutexType is the kind of lock of each shard, see WithMutexType.
+type MutexType int
+
+const (
+	// MutexRW is a sync.RWMutex, letting readers of a shard run in parallel.
+	MutexRW MutexType = iota
+	// MutexPlain is a sync.Mutex, cheaper to take, but exclusive even for
+	// reads.
+	MutexPlain
+)
+
+// WithMutexType sets the kind of lock of each shard, MutexRW by default. An
+// RWMutex costs more to take than a Mutex, which only pays off when readers of
+// the same shard often overlap. Write heavy maps, and maps used by few
+// goroutines, or with enough shards that they seldom meet, are faster with
+// MutexPlain. BenchmarkMutexType compares both over several write ratios; run
+// it on the target machine with its core count, as readers only overlap, and
+// MutexRW only pays off, with several cores.
+func WithMutexType(t MutexType) Option {
+	return func(o *options) {
+		o.mutexType = t
+	}
+}
+
+// WithBytesPerEntry sets the size, in bytes, that EstimatedBytes assumes for
+// each entry, key and map overhead included, but not the value itself (see
+// WithSizeOf). By default it's estimated from the key type: the key size, plus
+// the interface{} holding the value, plus some map bookkeeping.
+func WithBytesPerEntry(n int) Option {
+	return func(o *options) {
+		o.bytesPerEntry = n
+	}
+}
+
+// WithSizeOf makes EstimatedBytes add sizeOf(value) for each entry, for a
+// precise accounting of values, which are opaque to the map. It's only called
+// from EstimatedBytes, except by LRUStrMap, which calls it on every Store to
+// keep a running total (see WithMaxBytes), so keep it cheap there.
+func WithSizeOf(sizeOf func(value interface{}) int) Option {
+	return func(o *options) {
+		o.sizeOf = sizeOf
+	}
+}
+
+// WithMaxBytes bounds the total size of the values of an LRUStrMap, as
+// measured WithSizeOf, on top of its entry count: once a shard holds over its
+// share of n bytes, its least recently used entries are evicted. It has no
+// effect without WithSizeOf.
+//
+// Only LRUStrMap honours this option.
+func WithMaxBytes(n int64) Option {
+	return func(o *options) {
+		o.maxBytes = n
+	}
+}
+
+// entryBytes estimates the size of an entry whose key takes keyBytes.
+func (o *options) entryBytes(keyBytes int, value interface{}) int64 {
+	n := o.bytesPerEntry
+	if n <= 0 {
+		n = keyBytes + interfaceBytes + mapEntryOverhead
+	}
+	if o.sizeOf != nil {
+		n += o.sizeOf(value)
+	}
+	return int64(n)
+}
+
+// WithMaxShards overrides DefaultMaxShards, for the rare machines with so many
+// cores that they could use more shards.
+func WithMaxShards(n int) Option {
+	return func(o *options) {
+		o.maxShards = n
+	}
+}
+
+// shards returns the number of shards to create when asked for n: the default
+// if n <= 0, clamped to the maximum.
+func (o *options) shards(n int) int {
+	if n <= 0 {
+		n = defaultShards
+	}
+	max := o.maxShards
+	if max <= 0 {
+		max = DefaultMaxShards
+	}
+	if n > max {
+		n = max
+	}
+	return n
+}
+
+// WithExpectedSize presizes the shards of a map to hold total entries, so that
+// bulk loads don't grow them along the way. Go maps already fit as many
+// entries as they are sized for, but keys never spread perfectly evenly, and
+// a shard growing right at the end of a load rehashes it whole. So each shard
+// is sized for its even share of total divided by loadFactor, in (0, 1], for
+// headroom: 0.9 leaves about 11% extra room per shard. Out of range factors
+// count as 1, no headroom. A NewStrMapFromMap and alike map larger than total
+// is sized for its own contents instead.
+//
+// Only StrMap, Uint64Map and UUIDMap honour this option.
+func WithExpectedSize(total int, loadFactor float64) Option {
+	return func(o *options) {
+		o.expectedSize = total
+		o.loadFactor = loadFactor
+	}
+}
+
+// shardCapacity returns the initial capacity of each of n shards meant to hold
+// size entries in total, see WithExpectedSize.
+func (o *options) shardCapacity(size, n int) int {
+	if o.expectedSize > size {
+		size = o.expectedSize
+	}
+	capacity := float64(size) / float64(n)
+	if o.loadFactor > 0 && o.loadFactor < 1 {
+		capacity /= o.loadFactor
+	}
+	return int(math.Ceil(capacity))
+}
+
+// WithShardsPerCPU sets the k factor of the auto constructors, such as
+// NewStrMapAuto, which default to 4.
+func WithShardsPerCPU(k int) Option {
+	return func(o *options) {
+		o.shardsPerCPU = k
+	}
+}
+
+// autoShards returns the shard count of the auto constructors: the next power
+// of two >= runtime.NumCPU() * k.
+func (o *options) autoShards() int {
+	k := o.shardsPerCPU
+	if k <= 0 {
+		k = 4
+	}
+	n := 1
+	for n < runtime.NumCPU()*k {
+		n <<= 1
+	}
+	return n
+}
+
+// WithLockTimeout bounds how long the WithTimeout variants of the map methods,
+// such as StoreWithTimeout, wait for a shard lock before giving up with
+// ErrLockTimeout, to cap tail latencies when a lock is held abnormally long.
+// They poll the lock with exponential backoff, which adds some overhead over
+// the plain methods, whose signatures and behaviour don't change. Without this
+// option the WithTimeout variants wait indefinitely.
+func WithLockTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.lockTimeout = d
+	}
+}
+
+// WithDeterministicHash replaces the runtime hash used to pick the shard of
+// each key, which is seeded per process, with FNV-1a, so that the same key
+// always lands on the same shard across runs and machines (for a given shard
+// count and seed). It's meant for tests asserting shard placement, or for
+// cross-process consistency, and is somewhat slower on long keys. Note that
+// it's also predictable by attackers, unless WithSeed is kept secret.
+func WithDeterministicHash() Option {
+	return func(o *options) {
+		o.deterministic = true
+	}
+}
+
+// hashString hashes str to pick its shard.
+func (o *options) hashString(str string) uint64 {
+	if o.deterministic {
+		return fnvHashString(str, o.seed)
+	}
+	return memHashString(str, o.seed)
+}
+
+// shardHashString hashes the part of key that picks its shard: the whole key,
+// or its prefix WithStringPrefixShard.
+func (o *options) shardHashString(key string) uint64 {
+	if o.prefixShard {
+		if i := strings.IndexByte(key, o.prefixSep); i >= 0 {
+			key = key[:i]
+		}
+	}
+	return o.hashString(key)
+}
+
+// hashBytes hashes data to pick its shard.
+func (o *options) hashBytes(data []byte) uint64 {
+	if o.deterministic {
+		return fnvHash(data, o.seed)
+	}
+	return memHash(data, o.seed)
+}
+
+// hashUint64 hashes key to pick its shard.
+func (o *options) hashUint64(key uint64) uint64 {
+	if o.deterministic {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], key)
+		return fnvHash(b[:], o.seed)
+	}
+	return memHashUint64(key, o.seed)
+}
+
+// WithStrOnEvict sets a callback for every value a StrMap drops, either
+// replaced by a new one or deleted, to release the resources it holds. reason
+// tells which one happened, see EvictReason. The callback is called once the
+// shard lock is released, so it may use the map, but by then another value
+// might have been stored under key.
+//
+// Only StrMap honours this option.
+func WithStrOnEvict(f func(key string, value interface{}, reason EvictReason)) Option {
+	return func(o *options) {
+		o.strOnEvict = f
+	}
+}
+
+// WithUint64OnEvict is WithStrOnEvict for Uint64Map.
+func WithUint64OnEvict(f func(key uint64, value interface{}, reason EvictReason)) Option {
+	return func(o *options) {
+		o.uint64OnEvict = f
+	}
+}
+
+// WithUUIDOnEvict is WithStrOnEvict for UUIDMap.
+func WithUUIDOnEvict(f func(key UUID, value interface{}, reason EvictReason)) Option {
+	return func(o *options) {
+		o.uuidOnEvict = f
+	}
+}
+
+// WithCOWShards makes shards copy-on-write, for read mostly maps: every write
+// copies the whole shard, changes the copy and atomically publishes it, which
+// lets Load (and LoadOr) read the current copy without taking any lock. Other
+// reads still take the shard read lock.
+//
+// Writes become O(shard size) and allocate a new map each, and bulk writes
+// such as StoreMany copy once per key, so only use it when writes are rare
+// next to reads, and with enough shards to keep each one small. Sets and the
+// specialized maps ignore this option.
+func WithCOWShards() Option {
+	return func(o *options) {
+		o.cowShards = true
+	}
+}
+
+// WithShardSizeThreshold calls f, on a goroutine of its own and so outside of
+// any lock, when an insertion makes a shard grow past n entries, to spot
+// skewed shards as they develop. It's best effort: f is called at most once a
+// second per shard, so a shard going back and forth across n doesn't flood
+// it, and by the time it runs the shard may have shrunk again. size is that of
+// the shard right after the insertion.
+//
+// Only StrMap, Uint64Map and UUIDMap honour this option.
+func WithShardSizeThreshold(n int, f func(shard, size int)) Option {
+	return func(o *options) {
+		o.shardSizeLimit = n
+		o.onShardSize = f
+	}
+}
+
+// shardAlertInterval is the minimum time between calls of the
+// WithShardSizeThreshold callback for a shard.
+const shardAlertInterval = time.Second
+
+// alertShardSize calls the WithShardSizeThreshold callback for shard, unless
+// it was called at last, in UnixNano, within shardAlertInterval. last must be
+// guarded by the shard write lock.
+func (o *options) alertShardSize(last *int64, shard, size int) {
+	now := time.Now().UnixNano()
+	if *last != 0 && now-*last < int64(shardAlertInterval) {
+		return
+	}
+	*last = now
+	go o.onShardSize(shard, size)
+}
+
+// WithRangeSnapshots makes Range, All and the RangeTyped functions go over a
+// snapshot of each shard rather than the shard itself, so that they hold no
+// lock while f runs, for maps scanned nonstop, like by dashboards. Snapshots
+// are taken by Range itself, copying a shard under its read lock when its
+// snapshot is older than refresh, so writers of a shard are only blocked while
+// it's being re-copied, not while f runs. Range may miss writes done up to
+// refresh ago, and a Range that takes them costs as much as copying the map.
+// Every other method, the other Range variants and Snapshot included, still
+// reads the shards themselves. refresh <= 0 disables it, which is the default.
+//
+// Only StrMap, Uint64Map and UUIDMap honour this option.
+func WithRangeSnapshots(refresh time.Duration) Option {
+	return func(o *options) {
+		o.rangeRefresh = refresh
+	}
+}
+
+// WithoutReadFastPath makes LoadOrStore and LoadOrStoreFunc take the shard
+// write lock right away, instead of first looking the key up under the read
+// lock and then again under the write lock if it's missing. That fast path
+// only pays off when concurrent callers often find their key present; for
+// maps mostly filled with new keys, as in bulk builds, it's a wasted lock
+// round trip. It's safe under any concurrency: hits just no longer run in
+// parallel under the read lock. Store always takes a single lock.
+func WithoutReadFastPath() Option {
+	return func(o *options) {
+		o.noReadFastPath = true
+	}
+}
+
+// WithMaxParallelism bounds how many goroutines ConcRange, AsyncRange and
+// CompactParallel run at once, one per shard being visited, to n. The bound is
+// shared by every such call on the map: with several of them at a time, their
+// shards queue for the same n slots, so a range heavy service never has more
+// than n of those goroutines per map, at the cost of waiting for one another.
+// By default it's GOMAXPROCS when the map is created.
+func WithMaxParallelism(n int) Option {
+	return func(o *options) {
+		o.maxParallelism = n
+	}
+}
+
+// parallelism returns the limit set WithMaxParallelism, or GOMAXPROCS.
+func (o options) parallelism() int {
+	if o.maxParallelism > 0 {
+		return o.maxParallelism
+	}
+	return runtime.GOMAXPROCS(0)
+}
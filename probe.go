@@ -0,0 +1,132 @@
+package shardedmap
+
+import (
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProbeResult is the outcome of a contention probe, such as
+// StrMap.ContentionProbe.
+type ProbeResult struct {
+	Ops       int64         // Lock acquisitions done
+	Contended int64         // How many of them found the lock taken
+	Elapsed   time.Duration // Actual duration of the probe
+}
+
+// OpsPerSec returns the throughput of the probe.
+func (r ProbeResult) OpsPerSec() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Ops) / r.Elapsed.Seconds()
+}
+
+// ContentionRate returns the fraction of acquisitions that found the lock
+// taken and had to wait, from 0 to 1. Rates that stay high as shards are
+// added point to hot keys rather than too few shards.
+func (r ProbeResult) ContentionRate() float64 {
+	if r.Ops == 0 {
+		return 0
+	}
+	return float64(r.Contended) / float64(r.Ops)
+}
+
+// probe runs concurrency goroutines for duration, each repeatedly calling op
+// with a random index below n and whether to take the write lock, chosen with
+// probability writeRatio. op reports whether the lock was contended.
+func probe(concurrency int, duration time.Duration, writeRatio float64, n int, op func(i int, write bool) (contended bool)) ProbeResult {
+	if n == 0 || concurrency <= 0 {
+		return ProbeResult{}
+	}
+	var ops, contended int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(duration)
+	wg.Add(concurrency)
+	for g := 0; g < concurrency; g++ {
+		go func() {
+			var localOps, localContended int64
+			for time.Now().Before(deadline) {
+				// Check the clock every so often only, it costs more than a lock
+				for j := 0; j < 64; j++ {
+					if op(rand.IntN(n), rand.Float64() < writeRatio) {
+						localContended++
+					}
+					localOps++
+				}
+			}
+			atomic.AddInt64(&ops, localOps)
+			atomic.AddInt64(&contended, localContended)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	return ProbeResult{Ops: ops, Contended: contended, Elapsed: time.Since(start)}
+}
+
+// probeLock takes and releases m, for writing or reading, reporting whether
+// it was taken already. get is called while holding it.
+func probeLock(m *shardMutex, write bool, get func()) (contended bool) {
+	if write {
+		if contended = !m.TryLock(); contended {
+			m.Lock()
+		}
+		get()
+		m.Unlock()
+		return contended
+	}
+	if contended = !m.TryRLock(); contended {
+		m.RLock()
+	}
+	get()
+	m.RUnlock()
+	return contended
+}
+
+// ContentionProbe is a tuning aid to pick a shard count: it hammers the shard
+// locks of the map for duration from concurrency goroutines, with the keys
+// currently in the map as the key distribution, and reports throughput and how
+// often locks were found taken. Each operation locks the shard of a random
+// key, for writing with probability writeRatio and reading otherwise, and
+// looks the key up. No entry is modified, but the probe competes with the
+// regular users of the map, so run it at startup or on a copy, filled with
+// representative keys. An empty map reports nothing.
+func (sm *StrMap) ContentionProbe(concurrency int, duration time.Duration, writeRatio float64) ProbeResult {
+	var keys []string
+	sm.rangeShards(func(key string, _ interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return probe(concurrency, duration, writeRatio, len(keys), func(i int, write bool) bool {
+		shard := sm.pickShard(keys[i])
+		return probeLock(&sm.mutexes[shard], write, func() { _ = sm.maps[shard][keys[i]] })
+	})
+}
+
+// ContentionProbe is StrMap.ContentionProbe for Uint64Map.
+func (sm *Uint64Map) ContentionProbe(concurrency int, duration time.Duration, writeRatio float64) ProbeResult {
+	var keys []uint64
+	sm.rangeShards(func(key uint64, _ interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return probe(concurrency, duration, writeRatio, len(keys), func(i int, write bool) bool {
+		shard := sm.pickShard(keys[i])
+		return probeLock(&sm.mutexes[shard], write, func() { _ = sm.maps[shard][keys[i]] })
+	})
+}
+
+// ContentionProbe is StrMap.ContentionProbe for UUIDMap.
+func (sm *UUIDMap) ContentionProbe(concurrency int, duration time.Duration, writeRatio float64) ProbeResult {
+	var keys []UUID
+	sm.rangeShards(func(key UUID, _ interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return probe(concurrency, duration, writeRatio, len(keys), func(i int, write bool) bool {
+		shard := sm.pickShard(keys[i])
+		return probeLock(&sm.mutexes[shard], write, func() { _ = sm.maps[shard][keys[i]] })
+	})
+}
@@ -1,7 +1,15 @@
 package shardedmap
 
 import (
+	"context"
+	"fmt"
+	"iter"
+	"math/rand/v2"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Implementation: This is a sharded map so that the cost of locking is
@@ -10,44 +18,262 @@ import (
 // cores but we provide a general default.
 type StrMap struct {
 	shardCount uint64 // Don't alter after creation, no mutex here
-	mutexes    []sync.RWMutex
-	maps       []map[string]interface{}
+	id         uint64 // Orders its shard locks among maps, see MultiLock
+	count      int64  // Only maintained WithLenCounter, use atomics
+	epoch      uint64 // Incremented by Reset, use atomics
+	// The per shard slices below are allocated upon creation and never
+	// replaced, only their elements change, under the shard locks, so the
+	// slices themselves need no synchronization. Any structural change, like
+	// resharding, would break this, which is why maps have a fixed shard count.
+	mutexes   []shardMutex
+	maps      []map[string]interface{}
+	deletions []int                                    // Per shard, only WithShrinkThreshold
+	waiters   []map[string][]chan interface{}          // Per shard, see WaitLoad
+	subs      []map[string][]chan interface{}          // Per shard, see Subscribe
+	cow       []atomic.Pointer[map[string]interface{}] // Per shard, only WithCOWShards
+	alerts    []int64                                  // Per shard, only WithShardSizeThreshold
+	snaps     []atomic.Pointer[shardSnapshot[string]]  // Per shard, only WithRangeSnapshots
+	sem       chan struct{}                            // Bounds ConcRange and friends, see WithMaxParallelism
+	opts      options
 }
 
 // NewStrMap ...
-func NewStrMap(shardCount int) *StrMap {
-	if shardCount <= 0 {
-		shardCount = defaultShards
-	}
+func NewStrMap(shardCount int, opts ...Option) *StrMap {
+	return newStrMap(shardCount, nil, newOptions(opts))
+}
+
+// NewStrMapAuto creates a map with a shard count tuned to the machine: the next
+// power of two >= runtime.NumCPU() * k, where k is 4 unless set
+// WithShardsPerCPU. NumCPU is read upon creation.
+func NewStrMapAuto(opts ...Option) *StrMap {
+	o := newOptions(opts)
+	return newStrMap(o.autoShards(), nil, o)
+}
+
+// NewStrMapFromMap creates a map with the contents of m, with shards presized to
+// fit them.
+func NewStrMapFromMap(m map[string]interface{}, shardCount int, opts ...Option) *StrMap {
+	return newStrMap(shardCount, m, newOptions(opts))
+}
+
+// newStrMap creates a map holding the contents of m, which may be nil, with
+// shards presized to fit them.
+func newStrMap(shardCount int, m map[string]interface{}, o options) *StrMap {
+	shardCount = o.shards(shardCount)
 
 	sm := &StrMap{
 		shardCount: uint64(shardCount),
-		mutexes:    make([]sync.RWMutex, shardCount),
+		id:         nextMapID(),
+		mutexes:    newShardMutexes(shardCount, o),
 		maps:       make([]map[string]interface{}, shardCount),
+		waiters:    make([]map[string][]chan interface{}, shardCount),
+		subs:       make([]map[string][]chan interface{}, shardCount),
+		sem:        make(chan struct{}, o.parallelism()),
+		opts:       o,
 	}
 
+	capacity := o.shardCapacity(len(m), shardCount)
 	for i := range sm.maps {
-		sm.maps[i] = make(map[string]interface{})
+		sm.maps[i] = make(map[string]interface{}, capacity)
+	}
+	if sm.opts.shrinkThreshold > 0 {
+		sm.deletions = make([]int, shardCount)
+	}
+	if sm.opts.onShardSize != nil {
+		sm.alerts = make([]int64, shardCount)
+	}
+	if sm.opts.rangeRefresh > 0 {
+		sm.snaps = make([]atomic.Pointer[shardSnapshot[string]], shardCount)
+	}
+	// Not shared yet, no need to lock. Filled before copying on write is
+	// enabled, so that it's not done for every entry.
+	for key, value := range m {
+		sm.putLocked(sm.pickShard(key), key, value)
+	}
+	if sm.opts.cowShards {
+		sm.cow = make([]atomic.Pointer[map[string]interface{}], shardCount)
+		for i := range sm.cow {
+			sm.swapLocked(uint64(i), sm.maps[i])
+		}
 	}
 
 	return sm
 }
 
 func (sm *StrMap) pickShard(key string) uint64 {
-	return memHashString(key) % sm.shardCount
+	if sm.opts.strShardFunc != nil {
+		return sm.opts.strShardFunc(key) % sm.shardCount
+	}
+	return sm.opts.shardHashString(key) % sm.shardCount
+}
+
+func lessStr(a, b string) bool {
+	return a < b
+}
+
+// putLocked stores value under key in the given shard, which must be write
+// locked by the caller, and returns the value it replaced, if any. To spare a
+// lookup, the replaced value is only looked for when the entry count or the
+// eviction callback need it, otherwise replaced is always false.
+func (sm *StrMap) putLocked(shard uint64, key string, value interface{}) (old interface{}, replaced bool) {
+	if sm.opts.countLen || sm.opts.strOnEvict != nil {
+		old, replaced = sm.maps[shard][key]
+		if !replaced && sm.opts.countLen {
+			atomic.AddInt64(&sm.count, 1)
+		}
+	}
+	size := len(sm.maps[shard])
+	if sm.cow != nil {
+		m := sm.cloneLocked(shard, 1)
+		m[key] = value
+		sm.swapLocked(shard, m)
+	} else {
+		sm.maps[shard][key] = value
+	}
+	if sm.alerts != nil && size == sm.opts.shardSizeLimit && len(sm.maps[shard]) > size {
+		sm.opts.alertShardSize(&sm.alerts[shard], int(shard), size+1)
+	}
+	if sm.waiters[shard] != nil {
+		sm.wakeLocked(shard, key, value)
+	}
+	if sm.subs[shard] != nil {
+		sm.publishLocked(shard, key, value)
+	}
+	return old, replaced
+}
+
+// wakeLocked hands value to the WaitLoad callers waiting for key, if any. The
+// shard must be write locked by the caller.
+func (sm *StrMap) wakeLocked(shard uint64, key string, value interface{}) {
+	chans, ok := sm.waiters[shard][key]
+	if !ok {
+		return
+	}
+	for _, ch := range chans {
+		ch <- value // Buffered, and only ever sent once
+	}
+	delete(sm.waiters[shard], key)
+	if len(sm.waiters[shard]) == 0 {
+		sm.waiters[shard] = nil
+	}
+}
+
+// removeLocked deletes key from the given shard, which must be write locked by
+// the caller, and returns the value it removed, if any. Like with putLocked,
+// it's only looked for when needed, otherwise removed is always false.
+func (sm *StrMap) removeLocked(shard uint64, key string) (old interface{}, removed bool) {
+	if !sm.opts.countLen && sm.deletions == nil && sm.opts.strOnEvict == nil && sm.cow == nil {
+		delete(sm.maps[shard], key)
+		return nil, false
+	}
+	if old, removed = sm.maps[shard][key]; !removed {
+		return nil, false
+	}
+	if sm.cow != nil {
+		m := sm.cloneLocked(shard, 0)
+		delete(m, key)
+		sm.swapLocked(shard, m)
+	} else {
+		delete(sm.maps[shard], key)
+	}
+	if sm.opts.countLen {
+		atomic.AddInt64(&sm.count, -1)
+	}
+	if sm.deletions != nil {
+		sm.deletions[shard]++
+		if float64(sm.deletions[shard]) > sm.opts.shrinkThreshold*float64(len(sm.maps[shard])) {
+			sm.compactLocked(shard)
+		}
+	}
+	return old, removed
+}
+
+// evict calls the eviction callback, if any, for value.
+func (sm *StrMap) evict(key string, value interface{}, reason EvictReason) {
+	if sm.opts.strOnEvict != nil {
+		sm.opts.strOnEvict(key, value, reason)
+	}
+}
+
+// evictAll calls evict for each of evs.
+func (sm *StrMap) evictAll(evs []eviction[string]) {
+	for _, ev := range evs {
+		sm.evict(ev.key, ev.value, ev.reason)
+	}
+}
+
+// publishLocked sends value to the subscribers of key, if any, without ever
+// blocking: a subscriber that didn't receive the previous value gets it
+// replaced with this one. The shard must be write locked by the caller, which
+// makes it the only sender.
+func (sm *StrMap) publishLocked(shard uint64, key string, value interface{}) {
+	for _, ch := range sm.subs[shard][key] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- value
+	}
+}
+
+// bucket groups the indexes of keys by the shard each key belongs to, so that
+// bulk operations can take each shard lock once.
+func (sm *StrMap) bucket(keys []string) [][]int {
+	buckets := make([][]int, sm.shardCount)
+	for i, key := range keys {
+		shard := sm.pickShard(key)
+		buckets[shard] = append(buckets[shard], i)
+	}
+	return buckets
+}
+
+// compactLocked rehashes the given shard, which must be write locked by the
+// caller, into a fresh map sized to its live entries. Go maps never give back
+// the memory of deleted entries, so this is the only way to reclaim it.
+func (sm *StrMap) compactLocked(shard uint64) {
+	sm.swapLocked(shard, sm.cloneLocked(shard, 0))
+	if sm.deletions != nil {
+		sm.deletions[shard] = 0
+	}
+}
+
+// cloneLocked returns a copy of the given shard, which must be locked by the
+// caller, with room for extra more entries.
+func (sm *StrMap) cloneLocked(shard uint64, extra int) map[string]interface{} {
+	m := make(map[string]interface{}, len(sm.maps[shard])+extra)
+	for key, value := range sm.maps[shard] {
+		m[key] = value
+	}
+	return m
+}
+
+// swapLocked replaces the map of the given shard, which must be write locked
+// by the caller, publishing it to the lock free readers WithCOWShards.
+func (sm *StrMap) swapLocked(shard uint64, m map[string]interface{}) {
+	sm.maps[shard] = m
+	if sm.cow != nil {
+		sm.cow[shard].Store(&m)
+	}
 }
 
 // Store ...
 func (sm *StrMap) Store(key string, value interface{}) {
 	shard := sm.pickShard(key)
 	sm.mutexes[shard].Lock()
-	sm.maps[shard][key] = value
+	old, replaced := sm.putLocked(shard, key, value)
 	sm.mutexes[shard].Unlock()
+	if replaced {
+		sm.evict(key, old, EvictOverwrite)
+	}
 }
 
 // Load ...
 func (sm *StrMap) Load(key string) (interface{}, bool) {
 	shard := sm.pickShard(key)
+	if sm.cow != nil {
+		value, ok := (*sm.cow[shard].Load())[key]
+		return value, ok
+	}
 	sm.mutexes[shard].RLock()
 	value, ok := sm.maps[shard][key]
 	sm.mutexes[shard].RUnlock()
@@ -57,21 +283,23 @@ func (sm *StrMap) Load(key string) (interface{}, bool) {
 // LoadOrStore ...
 func (sm *StrMap) LoadOrStore(key string, value interface{}) (actual interface{}, loaded bool) {
 	shard := sm.pickShard(key)
-	sm.mutexes[shard].RLock()
 	// Fast path assuming value has a somewhat high chance of already being
-	// there.
-	if actual, loaded = sm.maps[shard][key]; loaded {
+	// there, unless WithoutReadFastPath.
+	if !sm.opts.noReadFastPath {
+		sm.mutexes[shard].RLock()
+		if actual, loaded = sm.maps[shard][key]; loaded {
+			sm.mutexes[shard].RUnlock()
+			return
+		}
 		sm.mutexes[shard].RUnlock()
-		return
 	}
-	sm.mutexes[shard].RUnlock()
 	// Gotta check again, unfortunately
 	sm.mutexes[shard].Lock()
 	if actual, loaded = sm.maps[shard][key]; loaded {
 		sm.mutexes[shard].Unlock()
 		return
 	}
-	sm.maps[shard][key] = value
+	sm.putLocked(shard, key, value)
 	sm.mutexes[shard].Unlock()
 	return value, loaded
 }
@@ -80,8 +308,11 @@ func (sm *StrMap) LoadOrStore(key string, value interface{}) (actual interface{}
 func (sm *StrMap) Delete(key string) {
 	shard := sm.pickShard(key)
 	sm.mutexes[shard].Lock()
-	delete(sm.maps[shard], key)
+	old, removed := sm.removeLocked(shard, key)
 	sm.mutexes[shard].Unlock()
+	if removed {
+		sm.evict(key, old, EvictDelete)
+	}
 }
 
 // Range is modeled after sync.Map.Range. It calls f sequentially for each key
@@ -92,7 +323,26 @@ func (sm *StrMap) Delete(key string) {
 // concurrently, Range may or may not visit it. Similarly, if a value is
 // modified concurrently, Range may visit the previous or newest version of said
 // value.
+//
+// Each shard is read locked while f runs over its entries, so f must not write
+// to the map. Whether the iteration completes or f stops it, Range always
+// releases the shard lock before returning, so the map is immediately writable
+// again.
+//
+// WithRangeSnapshots, Range instead goes over per shard snapshots without
+// holding any lock, see that option.
 func (sm *StrMap) Range(f func(key string, value interface{}) bool) {
+	if sm.snaps != nil {
+		sm.rangeSnapshots(f)
+		return
+	}
+	sm.rangeShards(f)
+}
+
+// rangeShards is Range over the shards themselves, under their read locks.
+// The methods built on Range use it, so that only Range and All go over
+// snapshots WithRangeSnapshots.
+func (sm *StrMap) rangeShards(f func(key string, value interface{}) bool) {
 	for shard := range sm.mutexes {
 		sm.mutexes[shard].RLock()
 		for key, value := range sm.maps[shard] {
@@ -105,6 +355,31 @@ func (sm *StrMap) Range(f func(key string, value interface{}) bool) {
 	}
 }
 
+// rangeSnapshots is Range WithRangeSnapshots.
+func (sm *StrMap) rangeSnapshots(f func(key string, value interface{}) bool) {
+	for shard := range sm.snaps {
+		for key, value := range sm.snapshot(shard) {
+			if !f(key, value) {
+				return
+			}
+		}
+	}
+}
+
+// snapshot returns the Range snapshot of shard, first taking a new one if it's
+// older than the refresh interval. Concurrent Ranges may both take it, which
+// is wasteful but harmless, as either one is fresh.
+func (sm *StrMap) snapshot(shard int) map[string]interface{} {
+	if snap := sm.snaps[shard].Load(); snap != nil && time.Since(snap.taken) < sm.opts.rangeRefresh {
+		return snap.m
+	}
+	sm.mutexes[shard].RLock()
+	m := sm.cloneLocked(uint64(shard), 0)
+	sm.mutexes[shard].RUnlock()
+	sm.snaps[shard].Store(&shardSnapshot[string]{m: m, taken: time.Now()})
+	return m
+}
+
 // ConcRange ranges concurrently over all the shards, calling f sequentially
 // over each shard's key and value. If f returns false, range stops the
 // iteration on that shard (but the other shards continue until completion).
@@ -113,24 +388,20 @@ func (sm *StrMap) Range(f func(key string, value interface{}) bool) {
 // concurrently, Range may or may not visit it. Similarly, if a value is
 // modified concurrently, Range may visit the previous or newest version of said
 // value.
+//
+// Shards are visited by at most as many goroutines at a time as
+// WithMaxParallelism allows, so f mustn't call ConcRange, AsyncRange or
+// CompactParallel on the same map, which could wait forever for a free slot.
 func (sm *StrMap) ConcRange(f func(key string, value interface{}) bool) {
-	var wg sync.WaitGroup
-	wg.Add(int(sm.shardCount))
-	for shard := range sm.mutexes {
-		go func(shard int) {
-			sm.mutexes[shard].RLock()
-			for key, value := range sm.maps[shard] {
-				if !f(key, value) {
-					sm.mutexes[shard].RUnlock()
-					wg.Done()
-					return
-				}
+	forEachShard(sm.sem, len(sm.mutexes), func(shard int) {
+		sm.mutexes[shard].RLock()
+		for key, value := range sm.maps[shard] {
+			if !f(key, value) {
+				break
 			}
-			sm.mutexes[shard].RUnlock()
-			wg.Done()
-		}(shard)
-	}
-	wg.Wait()
+		}
+		sm.mutexes[shard].RUnlock()
+	})
 }
 
 // AsyncRange is exactly like ConcRange, but doesn't wait until all shards are
@@ -138,16 +409,1519 @@ func (sm *StrMap) ConcRange(f func(key string, value interface{}) bool) {
 // on the same goroutine might get the before or after AsyncRange values, which
 // might be surprising behaviour. When that's not desirable, use ConcRange.
 func (sm *StrMap) AsyncRange(f func(key string, value interface{}) bool) {
+	go sm.ConcRange(f)
+}
+
+// Count returns how many entries satisfy pred, walking the shards under their
+// read lock like Range does, without collecting the matching entries.
+func (sm *StrMap) Count(pred func(key string, value interface{}) bool) int {
+	var n int
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		for key, value := range sm.maps[shard] {
+			if pred(key, value) {
+				n++
+			}
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+	return n
+}
+
+// RangeWithShard is like Range, but also passes f the index of the shard each
+// entry lives in. Shards are visited in order, so all the entries of a shard
+// are passed consecutively. If f returns false, range stops the iteration.
+func (sm *StrMap) RangeWithShard(f func(shard int, key string, value interface{}) bool) {
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		for key, value := range sm.maps[shard] {
+			if !f(shard, key, value) {
+				sm.mutexes[shard].RUnlock()
+				return
+			}
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+}
+
+// LoadOrStoreFunc is like LoadOrStore, but the value to store is only computed,
+// by calling f, when the key isn't present. f is called under the shard write
+// lock, so keep it short. The returned actual value is the loaded one if loaded
+// is true, or the one computed by f otherwise.
+func (sm *StrMap) LoadOrStoreFunc(key string, f func() interface{}) (actual interface{}, loaded bool) {
+	shard := sm.pickShard(key)
+	if !sm.opts.noReadFastPath {
+		sm.mutexes[shard].RLock()
+		if actual, loaded = sm.maps[shard][key]; loaded {
+			sm.mutexes[shard].RUnlock()
+			return
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+	sm.mutexes[shard].Lock()
+	if actual, loaded = sm.maps[shard][key]; loaded {
+		sm.mutexes[shard].Unlock()
+		return
+	}
+	actual = f()
+	sm.putLocked(shard, key, actual)
+	sm.mutexes[shard].Unlock()
+	return actual, false
+}
+
+// LenApprox returns the number of entries in the map. With WithLenCounter it
+// just loads the running count, otherwise it adds up the shard sizes under
+// their read lock. It's "approximate" only in that, under concurrent writes,
+// the result is a snapshot that might already be outdated when returned.
+func (sm *StrMap) LenApprox() int {
+	if sm.opts.countLen {
+		return int(atomic.LoadInt64(&sm.count))
+	}
+	var n int
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		n += len(sm.maps[shard])
+		sm.mutexes[shard].RUnlock()
+	}
+	return n
+}
+
+// LoadOr returns the value stored under key, or fallback if there's none.
+func (sm *StrMap) LoadOr(key string, fallback interface{}) interface{} {
+	if value, ok := sm.Load(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// RangeYielding is like Range, but doesn't hold a shard's read lock while f
+// runs, so slow callbacks don't block writers. For each shard it copies the
+// keys under the read lock, releases it, and then loads each key again right
+// before calling f, skipping the ones deleted in the meantime. If f returns
+// false, range stops the iteration.
+//
+// The consistency is weaker than Range's: keys inserted in a shard after its
+// keys were copied are missed, and each value is the one stored at the time
+// it's loaded, not when the shard was copied.
+func (sm *StrMap) RangeYielding(f func(key string, value interface{}) bool) {
+	var keys []string
 	for shard := range sm.mutexes {
-		go func(shard int) {
+		sm.mutexes[shard].RLock()
+		keys = keys[:0]
+		for key := range sm.maps[shard] {
+			keys = append(keys, key)
+		}
+		sm.mutexes[shard].RUnlock()
+
+		for _, key := range keys {
 			sm.mutexes[shard].RLock()
+			value, ok := sm.maps[shard][key]
+			sm.mutexes[shard].RUnlock()
+			if ok && !f(key, value) {
+				return
+			}
+		}
+	}
+}
+
+// Compact rehashes every shard into a fresh map sized to its live entries,
+// reclaiming the memory held by deleted ones. Each shard is write locked while
+// it's being compacted, which takes time proportional to its size.
+func (sm *StrMap) Compact() {
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].Lock()
+		sm.compactLocked(uint64(shard))
+		sm.mutexes[shard].Unlock()
+	}
+}
+
+// All returns an iterator over the map entries, for use with range-over-func.
+// It drives Range, so it has the same semantics and locking, and breaking out
+// of the loop releases the current shard lock.
+func (sm *StrMap) All() iter.Seq2[string, interface{}] {
+	return func(yield func(key string, value interface{}) bool) {
+		sm.Range(yield)
+	}
+}
+
+// RangeLimit calls f for at most n entries, stopping as soon as n have been
+// visited, even mid-shard. Which n entries are visited is nondeterministic, as
+// with Range.
+func (sm *StrMap) RangeLimit(n int, f func(key string, value interface{})) {
+	if n <= 0 {
+		return
+	}
+	sm.rangeShards(func(key string, value interface{}) bool {
+		f(key, value)
+		n--
+		return n > 0
+	})
+}
+
+// Page returns up to limit entries starting at cursor, the Cursor to pass to
+// get the next page, and whether the whole map has been paged through. Start
+// with the zero Cursor. A limit below 1 counts as 1, so that every call but
+// the last makes progress.
+//
+// Shards are paged in order, and within a shard by ascending key, which takes
+// sorting the remaining keys of each shard visited under its read lock. Pages
+// aren't a consistent snapshot: entries present for the whole paging are
+// returned exactly once, but those added or removed in between pages may or
+// may not be returned.
+func (sm *StrMap) Page(cursor Cursor, limit int) (entries []StrEntry, next Cursor, done bool) {
+	next = cursor
+	if limit < 1 {
+		limit = 1
+	}
+	for next.shard < len(sm.mutexes) && len(entries) < limit {
+		sm.mutexes[next.shard].RLock()
+		keys := make([]string, 0, len(sm.maps[next.shard]))
+		for key := range sm.maps[next.shard] {
+			if !next.started || lessStr(next.after.(string), key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return lessStr(keys[i], keys[j]) })
+		if n := limit - len(entries); len(keys) > n {
+			keys = keys[:n]
+		}
+		for _, key := range keys {
+			entries = append(entries, StrEntry{Key: key, Value: sm.maps[next.shard][key]})
+		}
+		sm.mutexes[next.shard].RUnlock()
+
+		if len(entries) < limit {
+			next = Cursor{shard: next.shard + 1}
+		} else {
+			next.after, next.started = keys[len(keys)-1], true
+		}
+	}
+	return entries, next, next.shard >= len(sm.mutexes)
+}
+
+// StoreSlices stores each of values under the key at the same index of keys,
+// taking each shard write lock once for all its keys. It returns
+// ErrLengthMismatch, storing nothing, if the slices lengths differ.
+func (sm *StrMap) StoreSlices(keys []string, values []interface{}) error {
+	if len(keys) != len(values) {
+		return ErrLengthMismatch
+	}
+	for shard, idxs := range sm.bucket(keys) {
+		if len(idxs) == 0 {
+			continue
+		}
+		var evs []eviction[string]
+		sm.mutexes[shard].Lock()
+		for _, i := range idxs {
+			if old, replaced := sm.putLocked(uint64(shard), keys[i], values[i]); replaced && sm.opts.strOnEvict != nil {
+				evs = append(evs, eviction[string]{keys[i], old, EvictOverwrite})
+			}
+		}
+		sm.mutexes[shard].Unlock()
+		sm.evictAll(evs)
+	}
+	return nil
+}
+
+// ShardCount returns the number of shards of the map.
+func (sm *StrMap) ShardCount() int {
+	return int(sm.shardCount)
+}
+
+// ShardSnapshot returns a copy of the contents of shard i, taken under its read
+// lock, or nil if there's no such shard. It's a point-in-time copy, so it can
+// be processed without holding any lock, but won't see later writes.
+func (sm *StrMap) ShardSnapshot(i int) map[string]interface{} {
+	if i < 0 || i >= len(sm.mutexes) {
+		return nil
+	}
+	sm.mutexes[i].RLock()
+	m := make(map[string]interface{}, len(sm.maps[i]))
+	for key, value := range sm.maps[i] {
+		m[key] = value
+	}
+	sm.mutexes[i].RUnlock()
+	return m
+}
+
+// Update atomically reads and replaces the value under key. f gets the current
+// value and whether there was one, and returns the new value and whether to
+// keep it: if keep is false the key is deleted instead. f is called under the
+// shard write lock, so no concurrent write can slip in between the read and the
+// write, but keep it short and don't call other methods of the map from it.
+// Update returns the value left under key and whether there's any.
+func (sm *StrMap) Update(key string, f func(value interface{}, loaded bool) (newValue interface{}, keep bool)) (value interface{}, ok bool) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].Lock()
+	old, loaded := sm.maps[shard][key]
+	value, ok = f(old, loaded)
+	reason := EvictOverwrite
+	if ok {
+		sm.putLocked(shard, key, value)
+	} else {
+		value = nil
+		reason = EvictDelete
+		if loaded {
+			sm.removeLocked(shard, key)
+		}
+	}
+	sm.mutexes[shard].Unlock()
+	if loaded {
+		sm.evict(key, old, reason)
+	}
+	return value, ok
+}
+
+// StrReadView reads a StrMap without taking any lock, for use while the map
+// is already read locked, as within WithReadLockAll. Views of a single shard,
+// as from RLockShard, only see that shard.
+type StrReadView struct {
+	sm     *StrMap
+	single bool
+	shard  uint64
+}
+
+// shards returns the range of shards the view sees.
+func (v StrReadView) shards() (from, to int) {
+	if v.single {
+		return int(v.shard), int(v.shard) + 1
+	}
+	return 0, len(v.sm.maps)
+}
+
+// Load ...
+func (v StrReadView) Load(key string) (interface{}, bool) {
+	shard := v.sm.pickShard(key)
+	if v.single && shard != v.shard {
+		panic("shardedmap: key outside of the locked shard")
+	}
+	value, ok := v.sm.maps[shard][key]
+	return value, ok
+}
+
+// Range is like StrMap.Range, without the locking.
+func (v StrReadView) Range(f func(key string, value interface{}) bool) {
+	from, to := v.shards()
+	for shard := from; shard < to; shard++ {
+		for key, value := range v.sm.maps[shard] {
+			if !f(key, value) {
+				return
+			}
+		}
+	}
+}
+
+// Len returns the number of entries in the view.
+func (v StrReadView) Len() int {
+	var n int
+	from, to := v.shards()
+	for shard := from; shard < to; shard++ {
+		n += len(v.sm.maps[shard])
+	}
+	return n
+}
+
+// WithReadLockAll calls f while holding the read locks of every shard at once,
+// taken in shard order, so that the view passed to f is a globally consistent
+// snapshot of the map, which can't happen shard by shard. Use it for backups or
+// correctness sensitive exports.
+//
+// Every writer blocks until f returns. Moreover, a blocked writer makes new
+// readers of its shard wait as well, so keep f short. Within f only use the
+// view: calling other methods of the map might deadlock.
+func (sm *StrMap) WithReadLockAll(f func(view StrReadView)) {
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+	}
+	f(StrReadView{sm: sm})
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RUnlock()
+	}
+}
+
+// RLockShard read locks shard i and returns a view of it, to batch many reads
+// of keys known to live there under a single lock acquisition (see ShardCount
+// and GroupByShard). Loading keys of other shards through the view panics, as
+// does an i out of range.
+//
+// The shard stays read locked, blocking its writers, until unlock is called,
+// which must happen exactly once. Call it right away with defer, since
+// forgetting it leaves the shard never writable again.
+func (sm *StrMap) RLockShard(i int) (view StrReadView, unlock func()) {
+	if i < 0 || i >= len(sm.mutexes) {
+		panic("shardedmap: shard index out of range")
+	}
+	sm.mutexes[i].RLock()
+	return StrReadView{sm: sm, single: true, shard: uint64(i)}, sm.mutexes[i].RUnlock
+}
+
+// Snapshot returns a copy of the map contents, copying each shard under its
+// read lock in turn. It's cheap on writers, but as with Range, writes to other
+// shards can interleave, so the copy may not reflect any single instant. Use
+// ConsistentSnapshot when that matters.
+func (sm *StrMap) Snapshot() map[string]interface{} {
+	m := make(map[string]interface{})
+	sm.rangeShards(func(key string, value interface{}) bool {
+		m[key] = value
+		return true
+	})
+	return m
+}
+
+// ConsistentSnapshot returns a copy of the map contents as of a single instant,
+// copied while holding all the shard read locks at once (see WithReadLockAll).
+// That blocks every writer for the whole copy, so use it sparingly.
+func (sm *StrMap) ConsistentSnapshot() map[string]interface{} {
+	var m map[string]interface{}
+	sm.WithReadLockAll(func(view StrReadView) {
+		m = make(map[string]interface{}, view.Len())
+		view.Range(func(key string, value interface{}) bool {
+			m[key] = value
+			return true
+		})
+	})
+	return m
+}
+
+// EstimatedBytes returns a rough estimate of the memory used by the map
+// entries, adding up the estimate of each shard taken under its read lock. Each
+// entry is estimated as set by WithBytesPerEntry, plus the size of its value if
+// WithSizeOf was given. It doesn't account for the memory Go maps keep after
+// deletes, see Compact.
+func (sm *StrMap) EstimatedBytes() int64 {
+	var n int64
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		if sm.opts.bytesPerEntry > 0 && sm.opts.sizeOf == nil {
+			n += int64(len(sm.maps[shard]) * sm.opts.bytesPerEntry)
+		} else {
 			for key, value := range sm.maps[shard] {
-				if !f(key, value) {
-					sm.mutexes[shard].RUnlock()
-					return
-				}
+				n += sm.opts.entryBytes(stringHeaderBytes+len(key), value)
 			}
-			sm.mutexes[shard].RUnlock()
-		}(shard)
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+	return n
+}
+
+// RangeErr is like Range, but for fallible callbacks: it stops at the first
+// error returned by f, releasing the shard lock, and returns it.
+func (sm *StrMap) RangeErr(f func(key string, value interface{}) error) error {
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		for key, value := range sm.maps[shard] {
+			if err := f(key, value); err != nil {
+				sm.mutexes[shard].RUnlock()
+				return err
+			}
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+	return nil
+}
+
+// RangeCopy is like Range, but passes f copyFn(value) instead of each value,
+// so that f gets its own deep copy to keep or mutate safely after the shard
+// lock is gone. Values stored in the map must otherwise be treated as read
+// only, as other goroutines may be reading them. copyFn runs under the shard
+// read lock, f too.
+func (sm *StrMap) RangeCopy(f func(key string, value interface{}) bool, copyFn func(value interface{}) interface{}) {
+	sm.rangeShards(func(key string, value interface{}) bool {
+		return f(key, copyFn(value))
+	})
+}
+
+// WaitLoad is like Load, but if key is absent it waits until it's stored or
+// ctx is done, whichever happens first, returning ctx.Err() in the latter case.
+// This makes the map usable as a simple futures store, where a goroutine
+// stores the result that others wait for.
+//
+// Each waiting call registers a channel with the key's shard until it returns,
+// which costs a small allocation. Once a shard has no waiters left, writes to
+// it pay nothing for the feature.
+func (sm *StrMap) WaitLoad(ctx context.Context, key string) (interface{}, error) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].RLock()
+	if value, ok := sm.maps[shard][key]; ok {
+		sm.mutexes[shard].RUnlock()
+		return value, nil
+	}
+	sm.mutexes[shard].RUnlock()
+	sm.mutexes[shard].Lock()
+	if value, ok := sm.maps[shard][key]; ok {
+		sm.mutexes[shard].Unlock()
+		return value, nil
+	}
+	ch := make(chan interface{}, 1)
+	if sm.waiters[shard] == nil {
+		sm.waiters[shard] = make(map[string][]chan interface{})
+	}
+	sm.waiters[shard][key] = append(sm.waiters[shard][key], ch)
+	sm.mutexes[shard].Unlock()
+
+	select {
+	case value := <-ch:
+		return value, nil
+	case <-ctx.Done():
+	}
+	sm.mutexes[shard].Lock()
+	chans := sm.waiters[shard][key]
+	for i := range chans {
+		if chans[i] == ch {
+			chans = append(chans[:i], chans[i+1:]...)
+			break
+		}
+	}
+	if len(chans) > 0 {
+		sm.waiters[shard][key] = chans
+	} else if sm.waiters[shard] != nil {
+		delete(sm.waiters[shard], key)
+		if len(sm.waiters[shard]) == 0 {
+			sm.waiters[shard] = nil
+		}
+	}
+	sm.mutexes[shard].Unlock()
+	// The value might have been handed over right before we unregistered.
+	select {
+	case value := <-ch:
+		return value, nil
+	default:
+		return nil, ctx.Err()
+	}
+}
+
+// Subscribe returns a channel that receives the value under key every time
+// it's stored, until cancel is called, which closes the channel. Cancel is
+// idempotent, and must be called to free the subscription.
+//
+// Stores never block on slow subscribers: the channel buffers a single value,
+// which a newer one replaces if it wasn't received yet. So subscribers may miss
+// intermediate values, but always get to see the latest stored one. Deletes
+// aren't notified.
+func (sm *StrMap) Subscribe(key string) (values <-chan interface{}, cancel func()) {
+	shard := sm.pickShard(key)
+	ch := make(chan interface{}, 1)
+	sm.mutexes[shard].Lock()
+	if sm.subs[shard] == nil {
+		sm.subs[shard] = make(map[string][]chan interface{})
+	}
+	sm.subs[shard][key] = append(sm.subs[shard][key], ch)
+	sm.mutexes[shard].Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			sm.mutexes[shard].Lock()
+			chans := sm.subs[shard][key]
+			for i := range chans {
+				if chans[i] == ch {
+					chans = append(chans[:i], chans[i+1:]...)
+					break
+				}
+			}
+			if len(chans) > 0 {
+				sm.subs[shard][key] = chans
+			} else {
+				delete(sm.subs[shard], key)
+				if len(sm.subs[shard]) == 0 {
+					sm.subs[shard] = nil
+				}
+			}
+			sm.mutexes[shard].Unlock()
+			close(ch)
+		})
+	}
+}
+
+// ScanBatches streams the map entries over the returned channel in batches of
+// up to batchSize, closing it when done or when ctx is done. A slow consumer
+// applies backpressure, and the channel must be drained, or ctx cancelled, to
+// let the producing goroutine exit.
+//
+// Each shard is copied under its read lock, which is released before sending
+// its entries, so no lock is held while waiting on the consumer, and memory is
+// bounded by the size of a shard. The flip side is that, as with
+// RangeYielding, the batches aren't a consistent snapshot: writes to a shard
+// after it's copied aren't seen.
+func (sm *StrMap) ScanBatches(ctx context.Context, batchSize int) <-chan []StrEntry {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	ch := make(chan []StrEntry)
+	go func() {
+		defer close(ch)
+		batch := make([]StrEntry, 0, batchSize)
+		var entries []StrEntry
+		for shard := range sm.mutexes {
+			sm.mutexes[shard].RLock()
+			entries = entries[:0]
+			for key, value := range sm.maps[shard] {
+				entries = append(entries, StrEntry{Key: key, Value: value})
+			}
+			sm.mutexes[shard].RUnlock()
+
+			for _, entry := range entries {
+				batch = append(batch, entry)
+				if len(batch) < batchSize {
+					continue
+				}
+				select {
+				case ch <- batch:
+				case <-ctx.Done():
+					return
+				}
+				batch = make([]StrEntry, 0, batchSize)
+			}
+		}
+		if len(batch) > 0 {
+			select {
+			case ch <- batch:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return ch
+}
+
+// StoreShard is like Store, but also returns the index of the shard written
+// to, as passed by RangeWithShard, for per shard write metrics.
+func (sm *StrMap) StoreShard(key string, value interface{}) int {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].Lock()
+	old, replaced := sm.putLocked(shard, key, value)
+	sm.mutexes[shard].Unlock()
+	if replaced {
+		sm.evict(key, old, EvictOverwrite)
+	}
+	return int(shard)
+}
+
+// StrShardTx reads and writes a single shard of a StrMap while it's write
+// locked, see WithShardLock.
+type StrShardTx struct {
+	sm      *StrMap
+	shard   uint64
+	evicted []eviction[string]
+}
+
+func (tx *StrShardTx) check(key string) {
+	if tx.sm.pickShard(key) != tx.shard {
+		panic("shardedmap: key outside of the locked shard")
+	}
+}
+
+// Load ...
+func (tx *StrShardTx) Load(key string) (interface{}, bool) {
+	tx.check(key)
+	value, ok := tx.sm.maps[tx.shard][key]
+	return value, ok
+}
+
+// Store ...
+func (tx *StrShardTx) Store(key string, value interface{}) {
+	tx.check(key)
+	if old, replaced := tx.sm.putLocked(tx.shard, key, value); replaced && tx.sm.opts.strOnEvict != nil {
+		tx.evicted = append(tx.evicted, eviction[string]{key, old, EvictOverwrite})
+	}
+}
+
+// Delete ...
+func (tx *StrShardTx) Delete(key string) {
+	tx.check(key)
+	if old, removed := tx.sm.removeLocked(tx.shard, key); removed && tx.sm.opts.strOnEvict != nil {
+		tx.evicted = append(tx.evicted, eviction[string]{key, old, EvictDelete})
+	}
+}
+
+// WithShardLock calls f with the write lock of the shard all keys belong to,
+// so that f can read and write them atomically through tx, as a small
+// transaction. It returns ErrCrossShard, without calling f, if keys belong to
+// different shards, which is the common case unless a custom shard function
+// co-locates them (see WithStrShardFunc and alike). Using tx with keys of
+// other shards panics, and calling other methods of the map from f might
+// deadlock. With no keys f isn't called.
+func (sm *StrMap) WithShardLock(keys []string, f func(tx *StrShardTx)) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	shard := sm.pickShard(keys[0])
+	for _, key := range keys[1:] {
+		if sm.pickShard(key) != shard {
+			return ErrCrossShard
+		}
+	}
+	tx := &StrShardTx{sm: sm, shard: shard}
+	sm.mutexes[shard].Lock()
+	f(tx)
+	sm.mutexes[shard].Unlock()
+	sm.evictAll(tx.evicted)
+	return nil
+}
+
+// GroupByShard returns all the entries of the map, partitioned by shard: the
+// i-th slice holds the entries of shard i, copied under its read lock. It's a
+// copy of the whole map, so it takes as much memory as its entries, but lets
+// workers process each shard without touching any lock.
+func (sm *StrMap) GroupByShard() [][]StrEntry {
+	groups := make([][]StrEntry, len(sm.mutexes))
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		groups[shard] = make([]StrEntry, 0, len(sm.maps[shard]))
+		for key, value := range sm.maps[shard] {
+			groups[shard] = append(groups[shard], StrEntry{Key: key, Value: value})
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+	return groups
+}
+
+// UpdateRetry sets the value under key to f(old, loaded), where old is the
+// current value, if loaded. It's what a CompareAndSwap retry loop achieves,
+// as in atomic.Value patterns, but in a single shot: f runs under the shard
+// write lock, so no update is ever lost and there's nothing to retry. It
+// returns the new value. See Update, which can also delete the key.
+func (sm *StrMap) UpdateRetry(key string, f func(old interface{}, loaded bool) interface{}) interface{} {
+	value, _ := sm.Update(key, func(old interface{}, loaded bool) (interface{}, bool) {
+		return f(old, loaded), true
+	})
+	return value
+}
+
+// Validate checks the internal invariants of the map, returning an error
+// describing the first one broken, if any: the shard count must match the
+// number of shards, and every shard must have its map. It's cheap enough for
+// liveness probes, taking each shard read lock in turn.
+func (sm *StrMap) Validate() error {
+	n := int(sm.shardCount)
+	if n <= 0 {
+		return fmt.Errorf("shardedmap: invalid shard count %d", n)
+	}
+	if len(sm.mutexes) != n || len(sm.maps) != n || len(sm.waiters) != n || len(sm.subs) != n {
+		return fmt.Errorf("shardedmap: shard count %d doesn't match the shards", n)
+	}
+	if sm.deletions != nil && len(sm.deletions) != n {
+		return fmt.Errorf("shardedmap: shard count %d doesn't match the deletion counts", n)
+	}
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		ok := sm.maps[shard] != nil
+		sm.mutexes[shard].RUnlock()
+		if !ok {
+			return fmt.Errorf("shardedmap: shard %d has a nil map", shard)
+		}
+	}
+	return nil
+}
+
+// LoadWithTimeout is like Load, but fails with ErrLockTimeout if the shard lock
+// can't be taken within the WithLockTimeout duration.
+func (sm *StrMap) LoadWithTimeout(key string) (interface{}, bool, error) {
+	shard := sm.pickShard(key)
+	if !sm.mutexes[shard].lockTimeout(sm.opts.lockTimeout, true) {
+		return nil, false, ErrLockTimeout
+	}
+	value, ok := sm.maps[shard][key]
+	sm.mutexes[shard].RUnlock()
+	return value, ok, nil
+}
+
+// StoreWithTimeout is like Store, but fails with ErrLockTimeout, storing
+// nothing, if the shard lock can't be taken within the WithLockTimeout
+// duration.
+func (sm *StrMap) StoreWithTimeout(key string, value interface{}) error {
+	shard := sm.pickShard(key)
+	if !sm.mutexes[shard].lockTimeout(sm.opts.lockTimeout, false) {
+		return ErrLockTimeout
+	}
+	old, replaced := sm.putLocked(shard, key, value)
+	sm.mutexes[shard].Unlock()
+	if replaced {
+		sm.evict(key, old, EvictOverwrite)
+	}
+	return nil
+}
+
+// DeleteWithTimeout is like Delete, but fails with ErrLockTimeout, deleting
+// nothing, if the shard lock can't be taken within the WithLockTimeout
+// duration.
+func (sm *StrMap) DeleteWithTimeout(key string) error {
+	shard := sm.pickShard(key)
+	if !sm.mutexes[shard].lockTimeout(sm.opts.lockTimeout, false) {
+		return ErrLockTimeout
+	}
+	old, removed := sm.removeLocked(shard, key)
+	sm.mutexes[shard].Unlock()
+	if removed {
+		sm.evict(key, old, EvictDelete)
+	}
+	return nil
+}
+
+// StoreMany stores all the entries of m, taking each shard write lock once for
+// all its keys.
+func (sm *StrMap) StoreMany(m map[string]interface{}) {
+	sm.storeMany(m, nil)
+}
+
+// StoreManyReport is like StoreMany, but also returns the keys of m that
+// already had a value, which got overwritten, to detect duplicates on bulk
+// loads that should have none.
+func (sm *StrMap) StoreManyReport(m map[string]interface{}) (overwritten []string) {
+	sm.storeMany(m, &overwritten)
+	return overwritten
+}
+
+func (sm *StrMap) storeMany(m map[string]interface{}, overwritten *[]string) {
+	buckets := make([][]string, sm.shardCount)
+	for key := range m {
+		shard := sm.pickShard(key)
+		buckets[shard] = append(buckets[shard], key)
+	}
+	for shard, keys := range buckets {
+		if len(keys) == 0 {
+			continue
+		}
+		var evs []eviction[string]
+		sm.mutexes[shard].Lock()
+		for _, key := range keys {
+			if overwritten != nil {
+				if _, ok := sm.maps[shard][key]; ok {
+					*overwritten = append(*overwritten, key)
+				}
+			}
+			if old, replaced := sm.putLocked(uint64(shard), key, m[key]); replaced && sm.opts.strOnEvict != nil {
+				evs = append(evs, eviction[string]{key, old, EvictOverwrite})
+			}
+		}
+		sm.mutexes[shard].Unlock()
+		sm.evictAll(evs)
+	}
+}
+
+// DeleteCheckEmpty is like Delete, but reports whether key was present, and
+// whether the map was empty right after deleting it, for reference counting
+// like cleanups. The emptiness check is done after the delete, so it's best
+// effort: concurrent inserts may have made it outdated by the time it returns.
+// With WithLenCounter it's O(1), otherwise it scans the shards until it finds
+// a non empty one.
+func (sm *StrMap) DeleteCheckEmpty(key string) (deleted, nowEmpty bool) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].Lock()
+	old, deleted := sm.maps[shard][key]
+	if deleted {
+		sm.removeLocked(shard, key)
+	}
+	sm.mutexes[shard].Unlock()
+	if deleted {
+		sm.evict(key, old, EvictDelete)
+	}
+	return deleted, sm.isEmpty()
+}
+
+// isEmpty reports whether the map has no entries, see DeleteCheckEmpty.
+func (sm *StrMap) isEmpty() bool {
+	if sm.opts.countLen {
+		return atomic.LoadInt64(&sm.count) == 0
+	}
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		n := len(sm.maps[shard])
+		sm.mutexes[shard].RUnlock()
+		if n > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Clear removes all the entries, one shard at a time, so it's not atomic:
+// concurrent stores into already cleared shards survive it. It doesn't call
+// the eviction callback, see ClearWithEvict for that.
+func (sm *StrMap) Clear() {
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].Lock()
+		sm.clearLocked(uint64(shard))
+		sm.mutexes[shard].Unlock()
+	}
+}
+
+// ClearWithEvict is like Clear, but calls the eviction callback with
+// EvictClear for every removed entry, to release the resources they hold.
+// Callbacks are called after unlocking each shard, which makes it O(n) unlike
+// Clear, so prefer Clear if there's no callback or nothing to release.
+func (sm *StrMap) ClearWithEvict() {
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].Lock()
+		m := sm.clearLocked(uint64(shard))
+		sm.mutexes[shard].Unlock()
+		if sm.opts.strOnEvict == nil {
+			continue
+		}
+		for key, value := range m {
+			sm.opts.strOnEvict(key, value, EvictClear)
+		}
+	}
+}
+
+// clearLocked replaces the given shard, which must be write locked by the
+// caller, with an empty map, and returns the previous one.
+func (sm *StrMap) clearLocked(shard uint64) map[string]interface{} {
+	m := sm.maps[shard]
+	sm.swapLocked(shard, make(map[string]interface{}))
+	if sm.opts.countLen {
+		atomic.AddInt64(&sm.count, -int64(len(m)))
+	}
+	if sm.deletions != nil {
+		sm.deletions[shard] = 0
+	}
+	return m
+}
+
+// ToSyncMap returns a sync.Map with a copy of the map contents, to hand
+// it to code still expecting one. Like Snapshot, it copies each shard under
+// its read lock in turn, so it may not reflect any single instant, and later
+// writes to either map aren't seen by the other.
+func (sm *StrMap) ToSyncMap() *sync.Map {
+	m := new(sync.Map)
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		for key, value := range sm.maps[shard] {
+			m.Store(key, value)
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+	return m
+}
+
+// NewStrMapFromSyncMap creates a map with the contents of m, read with its
+// Range, to migrate from sync.Map. m is left untouched, and as with Range,
+// writes to it during the copy may or may not be seen. It panics if m holds a
+// key that isn't a string.
+func NewStrMapFromSyncMap(m *sync.Map, shardCount int, opts ...Option) *StrMap {
+	contents := make(map[string]interface{})
+	m.Range(func(key, value interface{}) bool {
+		contents[key.(string)] = value
+		return true
+	})
+	return newStrMap(shardCount, contents, newOptions(opts))
+}
+
+// FindDuplicateValues returns the groups of keys whose values are equal by eq,
+// to find redundant entries to coalesce. Only groups of two or more keys are
+// returned, each sorted by key. It's meant for offline audits, not hot paths:
+// it snapshots the map like Snapshot, then compares each value with one of
+// every group found so far outside of any lock, which is O(n^2) in the worst
+// case.
+func (sm *StrMap) FindDuplicateValues(eq func(a, b interface{}) bool) [][]string {
+	m := sm.Snapshot()
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return lessStr(keys[i], keys[j]) })
+
+	var groups [][]string
+	for _, key := range keys {
+		found := false
+		for i := range groups {
+			if eq(m[groups[i][0]], m[key]) {
+				groups[i] = append(groups[i], key)
+				found = true
+				break
+			}
+		}
+		if !found {
+			groups = append(groups, []string{key})
+		}
+	}
+	dups := groups[:0]
+	for _, group := range groups {
+		if len(group) > 1 {
+			dups = append(dups, group)
+		}
+	}
+	return dups
+}
+
+// Rename atomically moves the value under oldKey to newKey, replacing any value
+// newKey had, and reports whether oldKey existed: if it didn't, nothing
+// changes. When both keys belong to different shards, both are write locked,
+// always lowest shard first so that concurrent renames can't deadlock, and
+// neither key can be seen with the value missing or duplicated. Renaming a key
+// to itself only reports whether it exists.
+func (sm *StrMap) Rename(oldKey, newKey string) (moved bool) {
+	from, to := sm.pickShard(oldKey), sm.pickShard(newKey)
+	sm.lockPair(from, to)
+	value, moved := sm.maps[from][oldKey]
+	var old interface{}
+	var replaced bool
+	if moved && oldKey != newKey {
+		sm.removeLocked(from, oldKey)
+		old, replaced = sm.putLocked(to, newKey, value)
+	}
+	sm.unlockPair(from, to)
+	if replaced {
+		sm.evict(newKey, old, EvictOverwrite)
+	}
+	return moved
+}
+
+// lockPair write locks shards a and b, in shard order, which every method
+// locking more than one shard must follow to avoid deadlocks.
+func (sm *StrMap) lockPair(a, b uint64) {
+	if a > b {
+		a, b = b, a
+	}
+	sm.mutexes[a].Lock()
+	if a != b {
+		sm.mutexes[b].Lock()
+	}
+}
+
+// unlockPair unlocks shards locked with lockPair.
+func (sm *StrMap) unlockPair(a, b uint64) {
+	sm.mutexes[a].Unlock()
+	if a != b {
+		sm.mutexes[b].Unlock()
+	}
+}
+
+// RangeExcept is like Range, but skips the keys in skip, to ignore the ones
+// already handled by a previous pass. Membership is checked right before each
+// key would be visited, so keys added to skip meanwhile are skipped too. A
+// nil skip skips nothing.
+func (sm *StrMap) RangeExcept(skip *StrSet, f func(key string, value interface{}) bool) {
+	sm.rangeShards(func(key string, value interface{}) bool {
+		if skip != nil && skip.Has(key) {
+			return true
+		}
+		return f(key, value)
+	})
+}
+
+// ShardToken returns the token of the shard key belongs to, to lock it
+// together with shards of other maps with MultiLock.
+func (sm *StrMap) ShardToken(key string) ShardToken {
+	shard := sm.pickShard(key)
+	return ShardToken{mapID: sm.id, shard: shard, mu: &sm.mutexes[shard]}
+}
+
+// LoadLocked is like Load, but without locking, for use while the shard of key
+// is already write locked by the caller, as with MultiLock.
+func (sm *StrMap) LoadLocked(key string) (interface{}, bool) {
+	value, ok := sm.maps[sm.pickShard(key)][key]
+	return value, ok
+}
+
+// StoreLocked is like Store, but without locking, for use while the shard of
+// key is already write locked by the caller, as with MultiLock. The eviction
+// callback of a replaced value, if any, is called before returning, with the
+// lock still held, so it must not use the map.
+func (sm *StrMap) StoreLocked(key string, value interface{}) {
+	if old, replaced := sm.putLocked(sm.pickShard(key), key, value); replaced {
+		sm.evict(key, old, EvictOverwrite)
+	}
+}
+
+// DeleteLocked is like Delete, but without locking, for use while the shard of
+// key is already write locked by the caller, as with MultiLock. As with
+// StoreLocked, the eviction callback is called with the lock still held.
+func (sm *StrMap) DeleteLocked(key string) {
+	if old, removed := sm.removeLocked(sm.pickShard(key), key); removed {
+		sm.evict(key, old, EvictDelete)
+	}
+}
+
+// RangeSample is like Range, but only visits each entry with probability
+// fraction, independently of the others, for approximate analytics over large
+// maps. The number of visited entries is thus random, around fraction times
+// the map size, not a fixed count. A fraction >= 1 visits every entry, and one
+// <= 0 none, though the shards are still scanned.
+func (sm *StrMap) RangeSample(fraction float64, f func(key string, value interface{}) bool) {
+	sm.rangeShards(func(key string, value interface{}) bool {
+		if rand.Float64() >= fraction {
+			return true
+		}
+		return f(key, value)
+	})
+}
+
+// CompactParallel is like Compact, but compacts several shards at once, each
+// in its own goroutine holding only that shard's write lock, to reclaim the
+// memory of large maps faster. At most as many as WithMaxParallelism allows
+// run at a time. It returns once every shard is done.
+func (sm *StrMap) CompactParallel() {
+	forEachShard(sm.sem, len(sm.mutexes), func(shard int) {
+		sm.mutexes[shard].Lock()
+		sm.compactLocked(uint64(shard))
+		sm.mutexes[shard].Unlock()
+	})
+}
+
+// Consume calls f for every entry and removes it, leaving each shard empty once
+// processed, to flush the map in a single pass, at shutdown say. Each shard is
+// write locked while its entries go through f, so every entry present when its
+// shard is reached is processed exactly once, and writers of that shard wait
+// meanwhile. As with Clear, shards are consumed in turn, so entries stored in
+// already consumed shards stay, and the eviction callback isn't called. f
+// must not use the map, which would deadlock.
+func (sm *StrMap) Consume(f func(key string, value interface{})) {
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].Lock()
+		for key, value := range sm.maps[shard] {
+			f(key, value)
+		}
+		sm.clearLocked(uint64(shard))
+		sm.mutexes[shard].Unlock()
+	}
+}
+
+// StreamKeys streams the map keys over the returned channel, buffered to hold
+// buf of them, closing it when done or when ctx is done. Like ScanBatches,
+// each shard's keys are copied under its read lock, which is released before
+// sending them, so memory is bounded by the keys of a shard rather than of
+// the whole map, and the keys aren't a consistent snapshot: writes to a shard
+// after it's copied aren't seen. The channel must be drained, or ctx
+// cancelled, to let the producing goroutine exit.
+func (sm *StrMap) StreamKeys(ctx context.Context, buf int) <-chan string {
+	if buf < 0 {
+		buf = 0
+	}
+	ch := make(chan string, buf)
+	go func() {
+		defer close(ch)
+		var keys []string
+		for shard := range sm.mutexes {
+			sm.mutexes[shard].RLock()
+			keys = keys[:0]
+			for key := range sm.maps[shard] {
+				keys = append(keys, key)
+			}
+			sm.mutexes[shard].RUnlock()
+
+			for _, key := range keys {
+				select {
+				case ch <- key:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// ShardKeys returns a copy of the keys in shard i, taken under its read lock,
+// or nil if there's no such shard, to inspect which keys cluster into a hot
+// shard. The keys come in no particular order.
+func (sm *StrMap) ShardKeys(i int) []string {
+	if i < 0 || i >= len(sm.mutexes) {
+		return nil
+	}
+	sm.mutexes[i].RLock()
+	keys := make([]string, 0, len(sm.maps[i]))
+	for key := range sm.maps[i] {
+		keys = append(keys, key)
+	}
+	sm.mutexes[i].RUnlock()
+	return keys
+}
+
+// RangeSafe is like Range, but recovers the panics of f, skipping to the next
+// entry, and returns them once done, so that a few bad entries don't abort a
+// bulk scan. The panics are recovered within the callback, so shard locks are
+// always released. It's meant for best effort scans: recovering may hide bugs,
+// leaving whatever f was doing half done, so prefer Range otherwise.
+func (sm *StrMap) RangeSafe(f func(key string, value interface{}) bool) []RangePanic {
+	var panics []RangePanic
+	sm.rangeShards(func(key string, value interface{}) bool {
+		ok, p := callSafe(f, key, value)
+		if p != nil {
+			panics = append(panics, *p)
+		}
+		return ok
+	})
+	return panics
+}
+
+// KeyLocker returns the write lock of the shard key belongs to, to hold it
+// across a compound operation the other methods don't express: load, do some
+// work, then conditionally store, say. While holding it, only use LoadLocked,
+// StoreLocked and DeleteLocked, and only with keys of the same shard (see
+// ShardToken): any other method of the map might deadlock, as would locking a
+// second shard in an order other than MultiLock's. The lock is shared by every
+// key of the shard, so holding it during slow work, like I/O, stalls them all.
+func (sm *StrMap) KeyLocker(key string) sync.Locker {
+	return &sm.mutexes[sm.pickShard(key)]
+}
+
+// CompareAndSwapFunc stores new under key if its current value is equal to old
+// by eq, atomically under the shard write lock, and reports whether it did.
+// Unlike ==, eq can compare values that aren't comparable, like structs
+// holding slices, which would make == panic. An absent key never matches. eq
+// is called with the current value first, under the lock, so keep it short
+// and don't use the map from it.
+func (sm *StrMap) CompareAndSwapFunc(key string, old, new interface{}, eq func(a, b interface{}) bool) (swapped bool) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].Lock()
+	current, ok := sm.maps[shard][key]
+	if swapped = ok && eq(current, old); swapped {
+		sm.putLocked(shard, key, new)
+	}
+	sm.mutexes[shard].Unlock()
+	if swapped {
+		sm.evict(key, current, EvictOverwrite)
+	}
+	return swapped
+}
+
+// LoadOrStoreMany is the bulk LoadOrStore, taking each shard write lock once
+// for all its keys in m: it stores the entries of m whose key is absent, which
+// it returns in inserted, and leaves the other ones as they are, returning
+// their current values, not those in m, in existing, which is nil if there
+// are none. Each key of m ends up in exactly one of them.
+func (sm *StrMap) LoadOrStoreMany(m map[string]interface{}) (inserted []string, existing map[string]interface{}) {
+	buckets := make([][]string, sm.shardCount)
+	for key := range m {
+		shard := sm.pickShard(key)
+		buckets[shard] = append(buckets[shard], key)
+	}
+	for shard, keys := range buckets {
+		if len(keys) == 0 {
+			continue
+		}
+		sm.mutexes[shard].Lock()
+		for _, key := range keys {
+			if actual, loaded := sm.maps[shard][key]; loaded {
+				if existing == nil {
+					existing = make(map[string]interface{})
+				}
+				existing[key] = actual
+				continue
+			}
+			sm.putLocked(uint64(shard), key, m[key])
+			inserted = append(inserted, key)
+		}
+		sm.mutexes[shard].Unlock()
+	}
+	return inserted, existing
+}
+
+// RangeValues is like Range, but only passes the values to f, for when the
+// keys don't matter, as when summing a field over all values.
+func (sm *StrMap) RangeValues(f func(value interface{}) bool) {
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		for _, value := range sm.maps[shard] {
+			if !f(value) {
+				sm.mutexes[shard].RUnlock()
+				return
+			}
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+}
+
+// Items returns a copy of the map entries, copying each shard under its read
+// lock in turn, so as with Snapshot, it may not reflect any single instant.
+// The entries come in no particular order.
+func (sm *StrMap) Items() []StrEntry {
+	var items []StrEntry
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		for key, value := range sm.maps[shard] {
+			items = append(items, StrEntry{Key: key, Value: value})
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+	return items
+}
+
+// TransformShard applies f to every entry of shard i, under its write lock: f
+// gets each key and value, and returns the new value and whether to keep it,
+// if keep is false the entry is deleted instead. It's Update for a whole
+// shard, and since shards are independent, bulk transforms can be spread over
+// goroutines, one per shard up to ShardCount. Writers of the shard wait until
+// f has gone through all its entries, so keep f short, and don't use the map
+// from it. Only deleted entries are reported to the eviction callback, as f
+// may well return the value it got: if it replaces one that needs releasing,
+// it's up to f to do so. It returns ErrNoSuchShard for out of range shards.
+func (sm *StrMap) TransformShard(i int, f func(key string, value interface{}) (newValue interface{}, keep bool)) error {
+	if i < 0 || i >= len(sm.mutexes) {
+		return ErrNoSuchShard
+	}
+	shard := uint64(i)
+	var evs []eviction[string]
+	sm.mutexes[i].Lock()
+	for key, value := range sm.maps[i] {
+		newValue, keep := f(key, value)
+		if keep {
+			sm.putLocked(shard, key, newValue)
+			continue
+		}
+		sm.removeLocked(shard, key)
+		if sm.opts.strOnEvict != nil {
+			evs = append(evs, eviction[string]{key, value, EvictDelete})
+		}
+	}
+	sm.mutexes[i].Unlock()
+	sm.evictAll(evs)
+	return nil
+}
+
+// ValueSizeHistogram tallies the sizes of the values, as measured by sizeOf,
+// into buckets, whose elements are ascending inclusive upper bounds: counts[j]
+// is the number of values of size <= buckets[j] and > buckets[j-1], and the
+// extra last count is for those larger than every bound. It's meant for
+// occasional capacity planning: it's O(n), calling sizeOf for every value
+// under the read lock of its shard.
+func (sm *StrMap) ValueSizeHistogram(sizeOf func(value interface{}) int, buckets []int) (counts []int) {
+	counts = make([]int, len(buckets)+1)
+	sm.RangeValues(func(value interface{}) bool {
+		counts[sort.SearchInts(buckets, sizeOf(value))]++
+		return true
+	})
+	return counts
+}
+
+// Reset empties the map in one go, while holding every shard write lock, and
+// starts a new epoch, for maps reused across generations of work, as from a
+// pool. Unlike Clear, no write can interleave with it. As with Clear, the
+// eviction callback isn't called.
+//
+// The epoch lets work of a previous generation that's still running, say a
+// straggling goroutine of an earlier request, tell it's now writing into a
+// recycled map: it takes the Epoch once upon starting, and then uses StoreGen
+// and LoadGen, which fail after a Reset:
+//
+//	epoch := m.Epoch()
+//	go func() {
+//		v := compute()
+//		if !m.StoreGen(key, v, epoch) {
+//			log.Print("map reset meanwhile, dropping stale result")
+//		}
+//	}()
+func (sm *StrMap) Reset() {
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].Lock()
+	}
+	for shard := range sm.mutexes {
+		sm.clearLocked(uint64(shard))
+	}
+	atomic.AddUint64(&sm.epoch, 1)
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].Unlock()
+	}
+}
+
+// Epoch returns the current epoch of the map, which starts at 0 and is
+// incremented by every Reset.
+func (sm *StrMap) Epoch() uint64 {
+	return atomic.LoadUint64(&sm.epoch)
+}
+
+// StoreGen is like Store, but only stores value if the map is still at epoch,
+// as returned by Epoch, and reports whether it did.
+func (sm *StrMap) StoreGen(key string, value interface{}, epoch uint64) bool {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].Lock()
+	// Reset holds every shard lock to change the epoch, so it can't change
+	// until unlocking
+	if atomic.LoadUint64(&sm.epoch) != epoch {
+		sm.mutexes[shard].Unlock()
+		return false
+	}
+	old, replaced := sm.putLocked(shard, key, value)
+	sm.mutexes[shard].Unlock()
+	if replaced {
+		sm.evict(key, old, EvictOverwrite)
+	}
+	return true
+}
+
+// LoadGen is like Load, but misses if the map is no longer at epoch, as
+// returned by Epoch, even if key is present.
+func (sm *StrMap) LoadGen(key string, epoch uint64) (interface{}, bool) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].RLock()
+	if atomic.LoadUint64(&sm.epoch) != epoch {
+		sm.mutexes[shard].RUnlock()
+		return nil, false
+	}
+	value, ok := sm.maps[shard][key]
+	sm.mutexes[shard].RUnlock()
+	return value, ok
+}
+
+// RangeDeadline is like Range, but stops once deadline has passed, for time
+// bounded maintenance sweeps, and reports whether it visited every shard in
+// full. The clock is only checked before each shard and every
+// rangeDeadlineEvery entries, to keep it cheap, so it may overrun deadline by
+// that many calls of f. As with Range, a false from f stops it too, which
+// also makes it incomplete.
+func (sm *StrMap) RangeDeadline(deadline time.Time, f func(key string, value interface{}) bool) (completed bool) {
+	for shard := range sm.mutexes {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		n := 0
+		sm.mutexes[shard].RLock()
+		for key, value := range sm.maps[shard] {
+			n++
+			if n%rangeDeadlineEvery == 0 && !time.Now().Before(deadline) || !f(key, value) {
+				sm.mutexes[shard].RUnlock()
+				return false
+			}
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+	return true
+}
+
+// DeleteByValue deletes every entry whose value is equal to target by eq, say
+// a "deleted" marker, and returns how many it deleted. eq, called with each
+// value first, can compare values that == can't. Each shard is write locked
+// while its values go through eq, so keep eq short, and don't use the map from
+// it. As with Clear, shards are processed in turn, so a matching value stored
+// in an already processed shard stays.
+func (sm *StrMap) DeleteByValue(target interface{}, eq func(a, b interface{}) bool) int {
+	var n int
+	for shard := range sm.mutexes {
+		var evs []eviction[string]
+		sm.mutexes[shard].Lock()
+		for key, value := range sm.maps[shard] {
+			if !eq(value, target) {
+				continue
+			}
+			sm.removeLocked(uint64(shard), key)
+			n++
+			if sm.opts.strOnEvict != nil {
+				evs = append(evs, eviction[string]{key, value, EvictDelete})
+			}
+		}
+		sm.mutexes[shard].Unlock()
+		sm.evictAll(evs)
+	}
+	return n
+}
+
+// StoreResult stores the outcome of a fallible computation under key, value
+// and err together, to cache failures as well, as for negative caching of
+// failed lookups. Read it back with LoadResult.
+func (sm *StrMap) StoreResult(key string, value interface{}, err error) {
+	sm.Store(key, result{value: value, err: err})
+}
+
+// LoadResult returns the value and error stored under key with StoreResult,
+// and whether there's any. Like with LoadWithTimeout, the error comes last. A
+// value stored by other means is returned as is, with a nil err.
+func (sm *StrMap) LoadResult(key string) (value interface{}, ok bool, err error) {
+	if value, ok = sm.Load(key); !ok {
+		return nil, false, nil
+	}
+	if r, isResult := value.(result); isResult {
+		return r.value, true, r.err
+	}
+	return value, true, nil
+}
+
+// RestoreShard stores entries straight into shard i, under its write lock,
+// without hashing their keys, for fast restores of ShardSnapshot exports. It
+// trusts the caller: every key must belong to shard i, which only holds for a
+// map with the same shard count, seed and shard function as the exported one.
+// Otherwise the misplaced keys can't be found, and storing them again makes
+// duplicates. It returns ErrNoSuchShard if i is out of range.
+func (sm *StrMap) RestoreShard(i int, entries map[string]interface{}) error {
+	if i < 0 || i >= len(sm.mutexes) {
+		return ErrNoSuchShard
+	}
+	shard := uint64(i)
+	var evs []eviction[string]
+	sm.mutexes[i].Lock()
+	for key, value := range entries {
+		if old, replaced := sm.putLocked(shard, key, value); replaced && sm.opts.strOnEvict != nil {
+			evs = append(evs, eviction[string]{key, old, EvictOverwrite})
+		}
+	}
+	sm.mutexes[i].Unlock()
+	sm.evictAll(evs)
+	return nil
+}
+
+// LoadOrdered loads the values of keys, returned in the same order: values[i]
+// is the value under keys[i], and found[i] whether there's one, with a nil
+// value if not, so that stored nil values can be told apart from missing
+// keys. Each shard read lock is taken once for all its keys, so it's cheaper
+// than calling Load for each of them, but the results aren't a consistent
+// snapshot, as writes to other shards can interleave.
+func (sm *StrMap) LoadOrdered(keys []string) (values []interface{}, found []bool) {
+	values = make([]interface{}, len(keys))
+	found = make([]bool, len(keys))
+	for shard, idxs := range sm.bucket(keys) {
+		if len(idxs) == 0 {
+			continue
+		}
+		sm.mutexes[shard].RLock()
+		for _, i := range idxs {
+			values[i], found[i] = sm.maps[shard][keys[i]]
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+	return values, found
+}
+
+// RangeOrdered is like Range, but visits the entries of each shard sorted by
+// key, for reproducible output, in tests or golden files say. The order is
+// only sorted within each shard, with shards still visited in turn, so it's
+// not globally sorted, but it's deterministic for a given key set, shard count
+// and key placement (see WithDeterministicHash). Each shard is copied under
+// its read lock and sorted, and f runs without holding any lock, on values
+// that may have been overwritten since.
+func (sm *StrMap) RangeOrdered(f func(key string, value interface{}) bool) {
+	var entries []StrEntry
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		entries = entries[:0]
+		for key, value := range sm.maps[shard] {
+			entries = append(entries, StrEntry{Key: key, Value: value})
+		}
+		sm.mutexes[shard].RUnlock()
+		sort.Slice(entries, func(i, j int) bool { return lessStr(entries[i].Key, entries[j].Key) })
+		for _, entry := range entries {
+			if !f(entry.Key, entry.Value) {
+				return
+			}
+		}
+	}
+}
+
+// DeletePrefix deletes every key starting with prefix, to evict a namespace,
+// say a tenant, and returns how many it deleted. It scans every shard in turn
+// under its write lock, so it's O(n), unless the map is
+// WithStringPrefixShard and prefix includes the separator: then every match
+// shares the shard of prefix, and only that one is scanned.
+func (sm *StrMap) DeletePrefix(prefix string) int {
+	from, to := 0, len(sm.mutexes)
+	if sm.opts.prefixShard && sm.opts.strShardFunc == nil && strings.IndexByte(prefix, sm.opts.prefixSep) >= 0 {
+		from = int(sm.pickShard(prefix))
+		to = from + 1
+	}
+	var n int
+	for shard := from; shard < to; shard++ {
+		var evs []eviction[string]
+		sm.mutexes[shard].Lock()
+		for key, value := range sm.maps[shard] {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			sm.removeLocked(uint64(shard), key)
+			n++
+			if sm.opts.strOnEvict != nil {
+				evs = append(evs, eviction[string]{key, value, EvictDelete})
+			}
+		}
+		sm.mutexes[shard].Unlock()
+		sm.evictAll(evs)
 	}
+	return n
 }
@@ -0,0 +1,460 @@
+package shardedmap
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockTimeout bounds how long tests wait on operations that must not block.
+const lockTimeout = time.Second
+
+// mustNotBlock fails the test if f doesn't return within lockTimeout.
+func mustNotBlock(t *testing.T, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(lockTimeout):
+		t.Fatalf("%s blocked for over %v, a shard lock leaked", what, lockTimeout)
+	}
+}
+
+// testRangeReleasesLocks stops a Range on the very first entry, and then on
+// an entry in the middle of a shard, checking each time that a Store to that
+// shard doesn't block afterwards. The map must have several shards, one of
+// them holding at least two entries.
+func testRangeReleasesLocks[K comparable](t *testing.T, shardCount int, shard func(i int) map[K]interface{}, rangeFn func(f func(key K, value interface{}) bool), store func(key K, value interface{})) {
+	t.Helper()
+	var first K
+	rangeFn(func(key K, _ interface{}) bool {
+		first = key
+		return false
+	})
+	mustNotBlock(t, "Store after stopping Range on the first entry", func() {
+		store(first, "again")
+	})
+
+	// Range visits shards in order, so the second entry of the first shard
+	// with two or more is preceded by all the entries of the previous ones.
+	skip := 0
+	var mid map[K]interface{}
+	for i := 0; i < shardCount; i++ {
+		m := shard(i)
+		if len(m) >= 2 {
+			mid = m
+			break
+		}
+		skip += len(m)
+	}
+	if mid == nil {
+		t.Fatal("no shard holds two entries")
+	}
+	var stopped K
+	n := 0
+	rangeFn(func(key K, _ interface{}) bool {
+		n++
+		if n < skip+2 {
+			return true
+		}
+		stopped = key
+		return false
+	})
+	if _, ok := mid[stopped]; !ok {
+		t.Fatalf("Range stopped at %v, outside the shard", stopped)
+	}
+	mustNotBlock(t, "Store after stopping Range mid-shard", func() {
+		store(stopped, "again")
+	})
+}
+
+func TestStrMapRangeEarlyReturnReleasesLock(t *testing.T) {
+	const shards = 4
+	sm := NewStrMap(shards)
+	for i := 0; i < 64; i++ {
+		sm.Store(strconv.Itoa(i), i)
+	}
+	testRangeReleasesLocks(t, shards, sm.ShardSnapshot, sm.Range, sm.Store)
+}
+
+// testLoadOrStoreFunc checks that loadOrStoreFunc returns the computed value
+// on insert, and doesn't compute anything on a hit.
+func testLoadOrStoreFunc[K comparable](t *testing.T, key K, loadOrStoreFunc func(key K, f func() interface{}) (interface{}, bool)) {
+	t.Helper()
+	computed := &struct{ n int }{1}
+	actual, loaded := loadOrStoreFunc(key, func() interface{} {
+		return computed
+	})
+	if loaded || actual != computed {
+		t.Fatalf("insert returned (%v, %v), want (%v, false)", actual, loaded, computed)
+	}
+	actual, loaded = loadOrStoreFunc(key, func() interface{} {
+		t.Error("f called on a hit")
+		return nil
+	})
+	if !loaded || actual != computed {
+		t.Fatalf("hit returned (%v, %v), want (%v, true)", actual, loaded, computed)
+	}
+}
+
+func TestStrMapLoadOrStoreFunc(t *testing.T) {
+	testLoadOrStoreFunc(t, "key", NewStrMap(4).LoadOrStoreFunc)
+	testLoadOrStoreFunc(t, "key", NewStrMap(4, WithoutReadFastPath()).LoadOrStoreFunc)
+}
+
+// tagged isn't comparable, as it holds a slice: == panics on it.
+type tagged struct {
+	tags []string
+}
+
+func taggedEqual(a, b interface{}) bool {
+	ta, ok := a.(tagged)
+	tb, ok2 := b.(tagged)
+	return ok && ok2 && slices.Equal(ta.tags, tb.tags)
+}
+
+func TestStrMapCompareAndSwapFunc(t *testing.T) {
+	sm := NewStrMap(4)
+	v1 := tagged{[]string{"a", "b"}}
+	v2 := tagged{[]string{"c"}}
+
+	if sm.CompareAndSwapFunc("key", v1, v2, taggedEqual) {
+		t.Error("swapped an absent key")
+	}
+	sm.Store("key", v1)
+	// A fresh copy, so that only eq can tell it's equal.
+	if !sm.CompareAndSwapFunc("key", tagged{[]string{"a", "b"}}, v2, taggedEqual) {
+		t.Error("didn't swap an equal value")
+	}
+	if got, _ := sm.Load("key"); !taggedEqual(got, v2) {
+		t.Errorf("Load after swap = %v, want %v", got, v2)
+	}
+	if sm.CompareAndSwapFunc("key", v1, tagged{}, taggedEqual) {
+		t.Error("swapped a value that's no longer equal")
+	}
+	if got, _ := sm.Load("key"); !taggedEqual(got, v2) {
+		t.Errorf("Load after failed swap = %v, want %v", got, v2)
+	}
+}
+
+func TestStrMapDeleteByValue(t *testing.T) {
+	deleted := tagged{[]string{"deleted"}}
+	sm := NewStrMap(4, WithLenCounter())
+	for i := 0; i < 20; i++ {
+		if i%4 == 0 {
+			sm.Store(strconv.Itoa(i), tagged{[]string{"deleted"}})
+		} else {
+			sm.Store(strconv.Itoa(i), tagged{[]string{strconv.Itoa(i)}})
+		}
+	}
+	if n := sm.DeleteByValue(deleted, taggedEqual); n != 5 {
+		t.Errorf("DeleteByValue deleted %d entries, want 5", n)
+	}
+	sm.Range(func(key string, value interface{}) bool {
+		if taggedEqual(value, deleted) {
+			t.Errorf("%q still holds the sentinel", key)
+		}
+		return true
+	})
+	if n := sm.LenApprox(); n != 15 {
+		t.Errorf("LenApprox = %d, want 15", n)
+	}
+}
+
+func TestStrMapStoreResult(t *testing.T) {
+	errNotFound := errors.New("not found")
+	sm := NewStrMap(4)
+	sm.StoreResult("miss", nil, errNotFound)
+	sm.StoreResult("hit", 42, nil)
+	sm.Store("plain", "value")
+
+	tests := []struct {
+		key       string
+		wantValue interface{}
+		wantOK    bool
+		wantErr   error
+	}{
+		{"miss", nil, true, errNotFound},
+		{"hit", 42, true, nil},
+		{"plain", "value", true, nil},
+		{"absent", nil, false, nil},
+	}
+	for _, tt := range tests {
+		value, ok, err := sm.LoadResult(tt.key)
+		if value != tt.wantValue || ok != tt.wantOK || !errors.Is(err, tt.wantErr) {
+			t.Errorf("LoadResult(%q) = (%v, %v, %v), want (%v, %v, %v)", tt.key, value, ok, err, tt.wantValue, tt.wantOK, tt.wantErr)
+		}
+	}
+}
+
+func TestStrMapDeletePrefix(t *testing.T) {
+	keys := []string{"tenant1:a", "tenant1:b", "tenant10:a", "tenant2:a", "other"}
+	tests := []struct {
+		prefix   string
+		wantLeft []string
+	}{
+		{"tenant1:", []string{"other", "tenant10:a", "tenant2:a"}},
+		{"tenant1", []string{"other", "tenant2:a"}},
+		{"tenant", []string{"other"}},
+		{"nobody:", keys},
+		{"", nil},
+	}
+	for _, opts := range [][]Option{nil, {WithStringPrefixShard(':')}} {
+		for _, tt := range tests {
+			sm := NewStrMap(8, opts...)
+			for _, key := range keys {
+				sm.Store(key, key)
+			}
+			n := sm.DeletePrefix(tt.prefix)
+			var left []string
+			sm.Range(func(key string, _ interface{}) bool {
+				left = append(left, key)
+				return true
+			})
+			slices.Sort(left)
+			want := slices.Sorted(slices.Values(tt.wantLeft))
+			if !slices.Equal(left, want) || n != len(keys)-len(want) {
+				t.Errorf("with %d options, DeletePrefix(%q) = %d leaving %v, want %d leaving %v",
+					len(opts), tt.prefix, n, left, len(keys)-len(want), want)
+			}
+		}
+	}
+}
+
+// BenchmarkStrMapLoadCOW compares parallel Loads of shards behind an RWMutex
+// with lock free ones WithCOWShards.
+func BenchmarkStrMapLoadCOW(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	for _, bm := range []struct {
+		name string
+		opts []Option
+	}{{"rwmutex", nil}, {"cow", []Option{WithCOWShards()}}} {
+		b.Run(bm.name, func(b *testing.B) {
+			sm := NewStrMap(16, bm.opts...)
+			for _, key := range keys {
+				sm.Store(key, key)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					sm.Load(keys[i%len(keys)])
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkStrMapExpectedSize stores 100k keys into a map left unsized, sized
+// for exactly as many, and sized with some headroom, WithExpectedSize.
+func BenchmarkStrMapExpectedSize(b *testing.B) {
+	const n = 100000
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	for _, bm := range []struct {
+		name string
+		opts []Option
+	}{
+		{"unsized", nil},
+		{"exact", []Option{WithExpectedSize(n, 1)}},
+		{"loadFactor=0.9", []Option{WithExpectedSize(n, 0.9)}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				sm := NewStrMap(64, bm.opts...)
+				for _, key := range keys {
+					sm.Store(key, nil)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkStrMapCompact compares Compact with CompactParallel, whose speedup
+// grows with GOMAXPROCS, up to the shard count: compare with several -cpu
+// values.
+func BenchmarkStrMapCompact(b *testing.B) {
+	sm := NewStrMap(64)
+	for i := 0; i < 1000000; i++ {
+		sm.Store(strconv.Itoa(i), nil)
+	}
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sm.Compact()
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sm.CompactParallel()
+		}
+	})
+}
+
+func TestStrMapTransformShard(t *testing.T) {
+	evicted := map[string]EvictReason{}
+	sm := NewStrMap(1, WithStrOnEvict(func(key string, _ interface{}, reason EvictReason) {
+		evicted[key] = reason
+	}))
+	sm.Store("a", 1)
+	sm.Store("b", 2)
+
+	noop := func(_ string, value interface{}) (interface{}, bool) {
+		return value, true
+	}
+	if err := sm.TransformShard(0, noop); err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 0 {
+		t.Errorf("a no-op transform evicted %v", evicted)
+	}
+
+	err := sm.TransformShard(0, func(key string, value interface{}) (interface{}, bool) {
+		return value.(int) * 10, key != "b"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicted) != 1 || evicted["b"] != EvictDelete {
+		t.Errorf("evicted %v, want only b deleted", evicted)
+	}
+	if got, _ := sm.Load("a"); got != 10 {
+		t.Errorf("a = %v, want 10", got)
+	}
+
+	for _, i := range []int{-1, 1} {
+		if err := sm.TransformShard(i, noop); !errors.Is(err, ErrNoSuchShard) {
+			t.Errorf("TransformShard(%d) error = %v, want ErrNoSuchShard", i, err)
+		}
+	}
+}
+
+func TestStrMapUpdate(t *testing.T) {
+	type evictEvent struct {
+		value  interface{}
+		reason EvictReason
+	}
+	var evicted []evictEvent
+	sm := NewStrMap(4, WithStrOnEvict(func(_ string, value interface{}, reason EvictReason) {
+		evicted = append(evicted, evictEvent{value, reason})
+	}))
+	inc := func(value interface{}, loaded bool) (interface{}, bool) {
+		n, _ := value.(int)
+		return n + 1, true
+	}
+
+	if value, ok := sm.Update("hits", inc); value != 1 || !ok {
+		t.Errorf("increment of absent key = (%v, %v), want (1, true)", value, ok)
+	}
+	if value, ok := sm.Update("hits", inc); value != 2 || !ok {
+		t.Errorf("increment of present key = (%v, %v), want (2, true)", value, ok)
+	}
+	if want := []evictEvent{{1, EvictOverwrite}}; !slices.Equal(evicted, want) {
+		t.Errorf("evicted %v after increments, want %v", evicted, want)
+	}
+
+	// Conditional delete, once the count reaches 2.
+	value, ok := sm.Update("hits", func(value interface{}, loaded bool) (interface{}, bool) {
+		return value, value.(int) < 2
+	})
+	if value != nil || ok {
+		t.Errorf("delete = (%v, %v), want (nil, false)", value, ok)
+	}
+	if _, ok := sm.Load("hits"); ok {
+		t.Error("key still present after delete")
+	}
+	if want := []evictEvent{{1, EvictOverwrite}, {2, EvictDelete}}; !slices.Equal(evicted, want) {
+		t.Errorf("evicted %v after delete, want %v", evicted, want)
+	}
+}
+
+func TestStrMapUpdateRetryLosesNoUpdates(t *testing.T) {
+	const goroutines, increments = 8, 1000
+	sm := NewStrMap(4)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				sm.UpdateRetry("counter", func(old interface{}, loaded bool) interface{} {
+					n, _ := old.(int)
+					return n + 1
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if got, _ := sm.Load("counter"); got != goroutines*increments {
+		t.Errorf("counter = %v, want %d", got, goroutines*increments)
+	}
+}
+
+func TestStrMapPage(t *testing.T) {
+	sm := NewStrMap(4)
+	for i := 0; i < 50; i++ {
+		sm.Store(strconv.Itoa(i), i)
+	}
+	for _, limit := range []int{-1, 0, 1, 7, 50, 100} {
+		seen := map[string]int{}
+		var cursor Cursor
+		for pages := 0; ; pages++ {
+			if pages > 60 {
+				t.Fatalf("limit %d: paging doesn't end", limit)
+			}
+			entries, next, done := sm.Page(cursor, limit)
+			if len(entries) > max(limit, 1) {
+				t.Fatalf("limit %d: got a page of %d entries", limit, len(entries))
+			}
+			for _, entry := range entries {
+				seen[entry.Key]++
+			}
+			if done {
+				break
+			}
+			cursor = next
+		}
+		if len(seen) != 50 {
+			t.Errorf("limit %d: paged through %d keys, want 50", limit, len(seen))
+		}
+		for key, n := range seen {
+			if n != 1 {
+				t.Errorf("limit %d: %q returned %d times", limit, key, n)
+			}
+		}
+	}
+}
+
+func TestStrMapRangeSnapshotsOnlyAffectRange(t *testing.T) {
+	sm := NewStrMap(1, WithRangeSnapshots(time.Hour))
+	sm.Store("a", 1)
+	sm.Range(func(string, interface{}) bool { return true })
+	sm.Store("b", 2)
+
+	n := 0
+	for range sm.All() {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("All visited %d entries, want the 1 of the snapshot", n)
+	}
+	if m := sm.Snapshot(); len(m) != 2 {
+		t.Errorf("Snapshot = %v, want both entries", m)
+	}
+	n = 0
+	sm.RangeLimit(10, func(string, interface{}) { n++ })
+	if n != 2 {
+		t.Errorf("RangeLimit visited %d entries, want 2", n)
+	}
+}
@@ -0,0 +1,72 @@
+package shardedmap
+
+import (
+	"slices"
+	"testing"
+)
+
+func newStrSetOf(shardCount int, keys ...string) *StrSet {
+	s := NewStrSet(shardCount)
+	for _, key := range keys {
+		s.Add(key)
+	}
+	return s
+}
+
+func strSetKeys(s *StrSet) []string {
+	keys := []string{}
+	s.Range(func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	slices.Sort(keys)
+	return keys
+}
+
+func TestStrSetOperations(t *testing.T) {
+	tests := []struct {
+		name                         string
+		a, b                         []string
+		union, intersect, difference []string
+	}{
+		{
+			name:       "disjoint",
+			a:          []string{"a", "b"},
+			b:          []string{"c", "d"},
+			union:      []string{"a", "b", "c", "d"},
+			intersect:  []string{},
+			difference: []string{"a", "b"},
+		},
+		{
+			name:       "identical",
+			a:          []string{"a", "b", "c"},
+			b:          []string{"a", "b", "c"},
+			union:      []string{"a", "b", "c"},
+			intersect:  []string{"a", "b", "c"},
+			difference: []string{},
+		},
+		{
+			name:       "partial overlap",
+			a:          []string{"a", "b", "c"},
+			b:          []string{"b", "c", "d"},
+			union:      []string{"a", "b", "c", "d"},
+			intersect:  []string{"b", "c"},
+			difference: []string{"a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Different shard counts, so keys don't sit in matching shards.
+			a, b := newStrSetOf(4, tt.a...), newStrSetOf(3, tt.b...)
+			if got := strSetKeys(a.Union(b)); !slices.Equal(got, tt.union) {
+				t.Errorf("Union = %v, want %v", got, tt.union)
+			}
+			if got := strSetKeys(a.Intersect(b)); !slices.Equal(got, tt.intersect) {
+				t.Errorf("Intersect = %v, want %v", got, tt.intersect)
+			}
+			if got := strSetKeys(a.Difference(b)); !slices.Equal(got, tt.difference) {
+				t.Errorf("Difference = %v, want %v", got, tt.difference)
+			}
+		})
+	}
+}
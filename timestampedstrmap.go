@@ -0,0 +1,133 @@
+package shardedmap
+
+import (
+	"sort"
+	"time"
+)
+
+// TimestampedStrMap is a StrMap that records when each entry was last stored,
+// so that incremental syncs can visit only what changed since the previous one
+// with RangeSince.
+type TimestampedStrMap struct {
+	shardCount uint64 // Don't alter after creation, no mutex here
+	mutexes    []shardMutex
+	maps       []map[string]timestamped
+	opts       options
+}
+
+type timestamped struct {
+	value   interface{}
+	modTime time.Time
+}
+
+// timestampedEntry is a timestamped value with its key, see RangeByModTime.
+type timestampedEntry struct {
+	key string
+	timestamped
+}
+
+// NewTimestampedStrMap ...
+func NewTimestampedStrMap(shardCount int, opts ...Option) *TimestampedStrMap {
+	o := newOptions(opts)
+	shardCount = o.shards(shardCount)
+
+	sm := &TimestampedStrMap{
+		shardCount: uint64(shardCount),
+		mutexes:    newShardMutexes(shardCount, o),
+		maps:       make([]map[string]timestamped, shardCount),
+		opts:       o,
+	}
+
+	for i := range sm.maps {
+		sm.maps[i] = make(map[string]timestamped)
+	}
+
+	return sm
+}
+
+func (sm *TimestampedStrMap) pickShard(key string) uint64 {
+	if sm.opts.strShardFunc != nil {
+		return sm.opts.strShardFunc(key) % sm.shardCount
+	}
+	return sm.opts.shardHashString(key) % sm.shardCount
+}
+
+// Store stores value under key, with the current time as its modification
+// time.
+func (sm *TimestampedStrMap) Store(key string, value interface{}) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].Lock()
+	sm.maps[shard][key] = timestamped{value: value, modTime: time.Now()}
+	sm.mutexes[shard].Unlock()
+}
+
+// Load returns the value under key and when it was stored.
+func (sm *TimestampedStrMap) Load(key string) (value interface{}, modTime time.Time, ok bool) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].RLock()
+	entry, ok := sm.maps[shard][key]
+	sm.mutexes[shard].RUnlock()
+	return entry.value, entry.modTime, ok
+}
+
+// Delete ...
+func (sm *TimestampedStrMap) Delete(key string) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].Lock()
+	delete(sm.maps[shard], key)
+	sm.mutexes[shard].Unlock()
+}
+
+// Range is like StrMap.Range, also passing the modification time of each
+// entry.
+func (sm *TimestampedStrMap) Range(f func(key string, value interface{}, modTime time.Time) bool) {
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		for key, entry := range sm.maps[shard] {
+			if !f(key, entry.value, entry.modTime) {
+				sm.mutexes[shard].RUnlock()
+				return
+			}
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+}
+
+// RangeSince is like Range, but only visits the entries stored after t.
+// Deleted entries aren't tracked, so they can't be visited.
+func (sm *TimestampedStrMap) RangeSince(t time.Time, f func(key string, value interface{}, modTime time.Time) bool) {
+	sm.Range(func(key string, value interface{}, modTime time.Time) bool {
+		if !modTime.After(t) {
+			return true
+		}
+		return f(key, value, modTime)
+	})
+}
+
+// RangeByModTime is like Range, but visits the entries in the order they were
+// last stored, oldest first, with ties broken by key, to replay changes in the
+// order they happened. It snapshots every entry first, shard by shard, and
+// sorts them, so it's O(n log n) with O(n) extra memory, and no lock is held
+// while f runs. As with Range, writes during the snapshot may or may not be
+// seen, so entries stored meanwhile can appear out of order or be missing.
+func (sm *TimestampedStrMap) RangeByModTime(f func(key string, value interface{}, modTime time.Time) bool) {
+	var entries []timestampedEntry
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		for key, entry := range sm.maps[shard] {
+			entries = append(entries, timestampedEntry{key, entry})
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].modTime.Equal(entries[j].modTime) {
+			return entries[i].modTime.Before(entries[j].modTime)
+		}
+		return entries[i].key < entries[j].key
+	})
+	for _, entry := range entries {
+		if !f(entry.key, entry.value, entry.modTime) {
+			return
+		}
+	}
+}
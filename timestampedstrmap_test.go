@@ -0,0 +1,37 @@
+package shardedmap
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTimestampedStrMapRangeSince(t *testing.T) {
+	sm := NewTimestampedStrMap(4)
+	sm.Store("old", 1)
+	sm.Store("renewed", 1)
+
+	// Leave some room on both sides of since, for coarse clocks.
+	time.Sleep(10 * time.Millisecond)
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	sm.Store("new", 2)
+	sm.Store("renewed", 2)
+
+	var keys []string
+	sm.RangeSince(since, func(key string, value interface{}, modTime time.Time) bool {
+		if value != 2 {
+			t.Errorf("%q has value %v, want 2", key, value)
+		}
+		if !modTime.After(since) {
+			t.Errorf("%q modified at %v, before %v", key, modTime, since)
+		}
+		keys = append(keys, key)
+		return true
+	})
+	slices.Sort(keys)
+	if want := []string{"new", "renewed"}; !slices.Equal(keys, want) {
+		t.Errorf("RangeSince visited %v, want %v", keys, want)
+	}
+}
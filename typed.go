@@ -0,0 +1,32 @@
+package shardedmap
+
+// RangeTyped is like sm.Range, but only calls f for the values of type T,
+// skipping the others, for maps holding values of mixed types.
+func RangeTyped[T any](sm *StrMap, f func(key string, value T) bool) {
+	sm.Range(func(key string, value interface{}) bool {
+		if v, ok := value.(T); ok {
+			return f(key, v)
+		}
+		return true
+	})
+}
+
+// RangeTypedUint64 is RangeTyped for Uint64Map.
+func RangeTypedUint64[T any](sm *Uint64Map, f func(key uint64, value T) bool) {
+	sm.Range(func(key uint64, value interface{}) bool {
+		if v, ok := value.(T); ok {
+			return f(key, v)
+		}
+		return true
+	})
+}
+
+// RangeTypedUUID is RangeTyped for UUIDMap.
+func RangeTypedUUID[T any](sm *UUIDMap, f func(key UUID, value T) bool) {
+	sm.Range(func(key UUID, value interface{}) bool {
+		if v, ok := value.(T); ok {
+			return f(key, v)
+		}
+		return true
+	})
+}
@@ -0,0 +1,50 @@
+package shardedmap
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUint64MapRangeEarlyReturnReleasesLock(t *testing.T) {
+	const shards = 4
+	sm := NewUint64Map(shards)
+	for i := uint64(0); i < 64; i++ {
+		sm.Store(i, i)
+	}
+	testRangeReleasesLocks(t, shards, sm.ShardSnapshot, sm.Range, sm.Store)
+}
+
+func TestUint64MapLoadOrStoreFunc(t *testing.T) {
+	testLoadOrStoreFunc(t, 42, NewUint64Map(4).LoadOrStoreFunc)
+}
+
+// BenchmarkUint64MapStore compares power of two shard counts, which pick
+// shards by masking, with others, which take a modulo.
+func BenchmarkUint64MapStore(b *testing.B) {
+	for _, shards := range []int{16, 15, 64, 63} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			sm := NewUint64Map(shards)
+			for i := 0; i < b.N; i++ {
+				sm.Store(uint64(i&1023), i)
+			}
+		})
+	}
+}
+
+// BenchmarkUint64MapBuild fills a map of 100k keys with LoadOrStore from a
+// single goroutine, with and without WithoutReadFastPath.
+func BenchmarkUint64MapBuild(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		opts []Option
+	}{{"default", nil}, {"noReadFastPath", []Option{WithoutReadFastPath()}}} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				sm := NewUint64Map(64, bm.opts...)
+				for key := uint64(0); key < 100000; key++ {
+					sm.LoadOrStore(key, nil)
+				}
+			}
+		})
+	}
+}
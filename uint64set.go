@@ -0,0 +1,152 @@
+package shardedmap
+
+// Uint64Set is a sharded set of uint64, built like Uint64Map but without values.
+type Uint64Set struct {
+	shardCount uint64 // Don't alter after creation, no mutex here
+	mutexes    []shardMutex
+	sets       []map[uint64]struct{}
+	opts       options
+}
+
+// NewUint64Set ...
+func NewUint64Set(shardCount int, opts ...Option) *Uint64Set {
+	return newUint64Set(shardCount, newOptions(opts))
+}
+
+func newUint64Set(shardCount int, opts options) *Uint64Set {
+	shardCount = opts.shards(shardCount)
+
+	s := &Uint64Set{
+		shardCount: uint64(shardCount),
+		mutexes:    newShardMutexes(shardCount, opts),
+		sets:       make([]map[uint64]struct{}, shardCount),
+		opts:       opts,
+	}
+
+	for i := range s.sets {
+		s.sets[i] = make(map[uint64]struct{})
+	}
+
+	return s
+}
+
+func (s *Uint64Set) pickShard(key uint64) uint64 {
+	if s.opts.uint64ShardFunc != nil {
+		return s.opts.uint64ShardFunc(key) % s.shardCount
+	}
+	// Same as Uint64Map.pickShard
+	if s.opts.seeded {
+		return s.opts.hashUint64(key) % s.shardCount
+	}
+	return key % s.shardCount
+}
+
+// Add ...
+func (s *Uint64Set) Add(key uint64) {
+	shard := s.pickShard(key)
+	s.mutexes[shard].Lock()
+	s.sets[shard][key] = struct{}{}
+	s.mutexes[shard].Unlock()
+}
+
+// Has ...
+func (s *Uint64Set) Has(key uint64) bool {
+	shard := s.pickShard(key)
+	s.mutexes[shard].RLock()
+	_, ok := s.sets[shard][key]
+	s.mutexes[shard].RUnlock()
+	return ok
+}
+
+// Remove ...
+func (s *Uint64Set) Remove(key uint64) {
+	shard := s.pickShard(key)
+	s.mutexes[shard].Lock()
+	delete(s.sets[shard], key)
+	s.mutexes[shard].Unlock()
+}
+
+// Len returns the number of keys in the set, adding up the shard sizes under
+// their read lock.
+func (s *Uint64Set) Len() int {
+	var n int
+	for shard := range s.mutexes {
+		s.mutexes[shard].RLock()
+		n += len(s.sets[shard])
+		s.mutexes[shard].RUnlock()
+	}
+	return n
+}
+
+// Range calls f sequentially for each key present in each of the shards in the
+// set. If f returns false, range stops the iteration. It has the same
+// consistency as Uint64Map.Range.
+func (s *Uint64Set) Range(f func(key uint64) bool) {
+	for shard := range s.mutexes {
+		s.mutexes[shard].RLock()
+		for key := range s.sets[shard] {
+			if !f(key) {
+				s.mutexes[shard].RUnlock()
+				return
+			}
+		}
+		s.mutexes[shard].RUnlock()
+	}
+}
+
+// keys returns a copy of the keys in each shard, taken under its read lock.
+func (s *Uint64Set) keys(shard int) []uint64 {
+	s.mutexes[shard].RLock()
+	keys := make([]uint64, 0, len(s.sets[shard]))
+	for key := range s.sets[shard] {
+		keys = append(keys, key)
+	}
+	s.mutexes[shard].RUnlock()
+	return keys
+}
+
+// The set operations below never hold locks of both sets at the same time, so
+// that concurrent operations in the opposite direction (a.Union(b) and
+// b.Union(a)) can't deadlock. They return a new set with the shard count and
+// options of s. Like Range, they don't see a consistent snapshot of either set
+// under concurrent writes.
+
+// Union returns a new set with the keys that are in s, other, or both.
+func (s *Uint64Set) Union(other *Uint64Set) *Uint64Set {
+	res := newUint64Set(int(s.shardCount), s.opts)
+	s.Range(func(key uint64) bool {
+		res.Add(key)
+		return true
+	})
+	other.Range(func(key uint64) bool {
+		res.Add(key)
+		return true
+	})
+	return res
+}
+
+// Intersect returns a new set with the keys that are both in s and other.
+func (s *Uint64Set) Intersect(other *Uint64Set) *Uint64Set {
+	res := newUint64Set(int(s.shardCount), s.opts)
+	for shard := range s.mutexes {
+		for _, key := range s.keys(shard) {
+			if other.Has(key) {
+				res.Add(key)
+			}
+		}
+	}
+	return res
+}
+
+// Difference returns a new set with the keys that are in s but not in other.
+func (s *Uint64Set) Difference(other *Uint64Set) *Uint64Set {
+	res := newUint64Set(int(s.shardCount), s.opts)
+	for shard := range s.mutexes {
+		for _, key := range s.keys(shard) {
+			if !other.Has(key) {
+				res.Add(key)
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,72 @@
+package shardedmap
+
+import (
+	"slices"
+	"testing"
+)
+
+func newUint64SetOf(shardCount int, keys ...uint64) *Uint64Set {
+	s := NewUint64Set(shardCount)
+	for _, key := range keys {
+		s.Add(key)
+	}
+	return s
+}
+
+func uint64SetKeys(s *Uint64Set) []uint64 {
+	keys := []uint64{}
+	s.Range(func(key uint64) bool {
+		keys = append(keys, key)
+		return true
+	})
+	slices.Sort(keys)
+	return keys
+}
+
+func TestUint64SetOperations(t *testing.T) {
+	tests := []struct {
+		name                         string
+		a, b                         []uint64
+		union, intersect, difference []uint64
+	}{
+		{
+			name:       "disjoint",
+			a:          []uint64{1, 2},
+			b:          []uint64{3, 4},
+			union:      []uint64{1, 2, 3, 4},
+			intersect:  []uint64{},
+			difference: []uint64{1, 2},
+		},
+		{
+			name:       "identical",
+			a:          []uint64{1, 2, 3},
+			b:          []uint64{1, 2, 3},
+			union:      []uint64{1, 2, 3},
+			intersect:  []uint64{1, 2, 3},
+			difference: []uint64{},
+		},
+		{
+			name:       "partial overlap",
+			a:          []uint64{1, 2, 3},
+			b:          []uint64{2, 3, 4},
+			union:      []uint64{1, 2, 3, 4},
+			intersect:  []uint64{2, 3},
+			difference: []uint64{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Different shard counts, so keys don't sit in matching shards.
+			a, b := newUint64SetOf(4, tt.a...), newUint64SetOf(3, tt.b...)
+			if got := uint64SetKeys(a.Union(b)); !slices.Equal(got, tt.union) {
+				t.Errorf("Union = %v, want %v", got, tt.union)
+			}
+			if got := uint64SetKeys(a.Intersect(b)); !slices.Equal(got, tt.intersect) {
+				t.Errorf("Intersect = %v, want %v", got, tt.intersect)
+			}
+			if got := uint64SetKeys(a.Difference(b)); !slices.Equal(got, tt.difference) {
+				t.Errorf("Difference = %v, want %v", got, tt.difference)
+			}
+		})
+	}
+}
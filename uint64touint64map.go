@@ -0,0 +1,111 @@
+package shardedmap
+
+// Uint64ToUint64Map is like Uint64Map, but specialized for uint64 values, which
+// are stored as is instead of boxed in an interface{}. That spares an
+// allocation per stored value, which adds up for high cardinality counters.
+type Uint64ToUint64Map struct {
+	shardCount uint64 // Don't alter after creation, no mutex here
+	mutexes    []shardMutex
+	maps       []map[uint64]uint64
+	opts       options
+}
+
+// NewUint64ToUint64Map ...
+func NewUint64ToUint64Map(shardCount int, opts ...Option) *Uint64ToUint64Map {
+	o := newOptions(opts)
+	shardCount = o.shards(shardCount)
+
+	sm := &Uint64ToUint64Map{
+		shardCount: uint64(shardCount),
+		mutexes:    newShardMutexes(shardCount, o),
+		maps:       make([]map[uint64]uint64, shardCount),
+		opts:       o,
+	}
+
+	for i := range sm.maps {
+		sm.maps[i] = make(map[uint64]uint64)
+	}
+
+	return sm
+}
+
+func (sm *Uint64ToUint64Map) pickShard(key uint64) uint64 {
+	// Same as Uint64Map.pickShard
+	if sm.opts.uint64ShardFunc != nil {
+		return sm.opts.uint64ShardFunc(key) % sm.shardCount
+	}
+	if sm.opts.seeded {
+		return sm.opts.hashUint64(key) % sm.shardCount
+	}
+	return key % sm.shardCount
+}
+
+// Store ...
+func (sm *Uint64ToUint64Map) Store(key, value uint64) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].Lock()
+	sm.maps[shard][key] = value
+	sm.mutexes[shard].Unlock()
+}
+
+// Load ...
+func (sm *Uint64ToUint64Map) Load(key uint64) (uint64, bool) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].RLock()
+	value, ok := sm.maps[shard][key]
+	sm.mutexes[shard].RUnlock()
+	return value, ok
+}
+
+// LoadOrStore ...
+func (sm *Uint64ToUint64Map) LoadOrStore(key, value uint64) (actual uint64, loaded bool) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].RLock()
+	if actual, loaded = sm.maps[shard][key]; loaded {
+		sm.mutexes[shard].RUnlock()
+		return
+	}
+	sm.mutexes[shard].RUnlock()
+	sm.mutexes[shard].Lock()
+	if actual, loaded = sm.maps[shard][key]; loaded {
+		sm.mutexes[shard].Unlock()
+		return
+	}
+	sm.maps[shard][key] = value
+	sm.mutexes[shard].Unlock()
+	return value, loaded
+}
+
+// Add atomically adds delta to the value under key, which starts at zero if
+// absent, and returns the new value. Like with any uint64, subtracting x is
+// adding ^uint64(x-1), and overflows wrap around.
+func (sm *Uint64ToUint64Map) Add(key, delta uint64) uint64 {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].Lock()
+	value := sm.maps[shard][key] + delta
+	sm.maps[shard][key] = value
+	sm.mutexes[shard].Unlock()
+	return value
+}
+
+// Delete ...
+func (sm *Uint64ToUint64Map) Delete(key uint64) {
+	shard := sm.pickShard(key)
+	sm.mutexes[shard].Lock()
+	delete(sm.maps[shard], key)
+	sm.mutexes[shard].Unlock()
+}
+
+// Range is like Uint64Map.Range.
+func (sm *Uint64ToUint64Map) Range(f func(key, value uint64) bool) {
+	for shard := range sm.mutexes {
+		sm.mutexes[shard].RLock()
+		for key, value := range sm.maps[shard] {
+			if !f(key, value) {
+				sm.mutexes[shard].RUnlock()
+				return
+			}
+		}
+		sm.mutexes[shard].RUnlock()
+	}
+}
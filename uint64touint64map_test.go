@@ -0,0 +1,26 @@
+package shardedmap
+
+import (
+	"testing"
+)
+
+// BenchmarkUint64ToUint64MapStore compares storing uint64 values unboxed with
+// storing them in a Uint64Map, which boxes each one. Values are kept large,
+// as the runtime boxes small ones without allocating.
+func BenchmarkUint64ToUint64MapStore(b *testing.B) {
+	const big = 1 << 20
+	b.Run("unboxed", func(b *testing.B) {
+		sm := NewUint64ToUint64Map(64)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sm.Store(uint64(i&1023), uint64(i+big))
+		}
+	})
+	b.Run("interface", func(b *testing.B) {
+		sm := NewUint64Map(64)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sm.Store(uint64(i&1023), uint64(i+big))
+		}
+	})
+}
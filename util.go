@@ -1,13 +1,24 @@
 package shardedmap
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"runtime"
+	"sync"
+	"time"
 	"unsafe"
 )
 
 //nolint:gochecknoglobals
 var defaultShards = runtime.NumCPU() * 16 // github.com/tidwall/shardmap recommendation
 
+// Rough sizes, in bytes on 64 bits platforms, used to estimate memory usage.
+const (
+	stringHeaderBytes = 16
+	interfaceBytes    = 16
+	mapEntryOverhead  = 8 // Control byte plus the slack left by the load factor
+)
+
 // Adapted from https://github.com/dgraph-io/ristretto/blob/master/z/rtutil.go
 //
 // MIT License
@@ -28,15 +39,92 @@ type stringStruct struct {
 // memHash is the hash function used by go map, it utilizes available hardware instructions(behaves
 // as aeshash if aes instruction is available).
 // NOTE: The hash seed changes for every process. So, this cannot be used as a persistent hash.
-func memHash(data []byte) uint64 {
+func memHash(data []byte, seed uint64) uint64 {
 	ss := (*stringStruct)(unsafe.Pointer(&data))
-	return uint64(rtmemhash(ss.str, 0, uintptr(ss.len)))
+	return uint64(rtmemhash(ss.str, uintptr(seed), uintptr(ss.len)))
 }
 
 // memHashString is the hash function used by go map, it utilizes available hardware instructions
 // (behaves as aeshash if aes instruction is available).
 // NOTE: The hash seed changes for every process. So, this cannot be used as a persistent hash.
-func memHashString(str string) uint64 {
+func memHashString(str string, seed uint64) uint64 {
 	ss := (*stringStruct)(unsafe.Pointer(&str))
-	return uint64(rtmemhash(ss.str, 0, uintptr(ss.len)))
+	return uint64(rtmemhash(ss.str, uintptr(seed), uintptr(ss.len)))
+}
+
+// memHashUint64 is memHash over the 8 bytes of key.
+func memHashUint64(key, seed uint64) uint64 {
+	return uint64(rtmemhash(unsafe.Pointer(&key), uintptr(seed), 8))
+}
+
+// FNV-1a parameters, see https://www.isthe.com/chongo/tech/comp/fnv
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// fnvHash is the 64 bits FNV-1a hash of data, with seed mixed in the offset
+// basis. Unlike memHash it's stable across processes and machines.
+func fnvHash(data []byte, seed uint64) uint64 {
+	h := uint64(fnvOffset64) ^ seed
+	for _, b := range data {
+		h ^= uint64(b)
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// fnvHashString is fnvHash for strings, without converting them to []byte.
+func fnvHashString(str string, seed uint64) uint64 {
+	h := uint64(fnvOffset64) ^ seed
+	for i := 0; i < len(str); i++ {
+		h ^= uint64(str[i])
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// powerOfTwoMask returns n-1 if n is a power of two greater than 1, for masking
+// instead of taking the modulo, and 0 otherwise.
+func powerOfTwoMask(n int) uint64 {
+	if n > 1 && n&(n-1) == 0 {
+		return uint64(n - 1)
+	}
+	return 0
+}
+
+// randomSeed returns a seed from crypto/rand, so that it can't be guessed.
+func randomSeed() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("shardedmap: can't read random seed: " + err.Error())
+	}
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+// rangeDeadlineEvery is how many entries RangeDeadline visits between checks
+// of the clock.
+const rangeDeadlineEvery = 64
+
+// shardSnapshot is a copy of a shard taken at some time, see
+// WithRangeSnapshots.
+type shardSnapshot[K comparable] struct {
+	m     map[K]interface{}
+	taken time.Time
+}
+
+// forEachShard calls f for every shard below n, each on its own goroutine,
+// starting one only once it gets a slot in sem, and returns when all are done.
+func forEachShard(sem chan struct{}, n int, f func(shard int)) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for shard := 0; shard < n; shard++ {
+		sem <- struct{}{}
+		go func(shard int) {
+			f(shard)
+			<-sem
+			wg.Done()
+		}(shard)
+	}
+	wg.Wait()
 }
@@ -0,0 +1,55 @@
+package shardedmap
+
+import (
+	"crypto/rand"
+)
+
+// UUID is the [16]byte representation most Go UUID libraries use underneath, so
+// their values can be converted without encoding.
+type UUID [16]byte
+
+// IsZero reports whether u is the zero (nil) UUID.
+func (u UUID) IsZero() bool {
+	return u == UUID{}
+}
+
+// UUID variants, as returned by UUID.Variant. See RFC 4122 section 4.1.1.
+const (
+	VariantNCS       = iota // Reserved, NCS backward compatibility
+	VariantRFC4122          // The one of every UUID version of RFC 4122
+	VariantMicrosoft        // Reserved, Microsoft backward compatibility
+	VariantFuture           // Reserved for future definition
+)
+
+// Version returns the version number of u, from its 4 most significant bits of
+// byte 6: 1 for time based UUIDs, 4 for random ones, and so on. It's only
+// meaningful for VariantRFC4122 UUIDs.
+func (u UUID) Version() int {
+	return int(u[6] >> 4)
+}
+
+// Variant returns the variant of u, encoded in the most significant bits of
+// byte 8, as one of the Variant constants.
+func (u UUID) Variant() int {
+	switch {
+	case u[8]&0x80 == 0:
+		return VariantNCS
+	case u[8]&0xc0 == 0x80:
+		return VariantRFC4122
+	case u[8]&0xe0 == 0xc0:
+		return VariantMicrosoft
+	default:
+		return VariantFuture
+	}
+}
+
+// NewRandomUUID returns a new random (version 4) UUID, read from crypto/rand.
+func NewRandomUUID() (UUID, error) {
+	var u UUID
+	if _, err := rand.Read(u[:]); err != nil {
+		return u, err
+	}
+	u[6] = u[6]&0x0f | 0x40 // Version 4
+	u[8] = u[8]&0x3f | 0x80 // Variant RFC 4122
+	return u, nil
+}
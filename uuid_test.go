@@ -0,0 +1,66 @@
+package shardedmap
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// mustParseUUID parses the canonical text form of a UUID.
+func mustParseUUID(t *testing.T, s string) UUID {
+	t.Helper()
+	var u UUID
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != len(u) {
+		t.Fatalf("invalid UUID %q", s)
+	}
+	copy(u[:], b)
+	return u
+}
+
+func TestUUIDVersionVariant(t *testing.T) {
+	tests := []struct {
+		name        string
+		uuid        string
+		wantVersion int
+		wantVariant int
+	}{
+		{"v1", "6ba7b810-9dad-11d1-80b4-00c04fd430c8", 1, VariantRFC4122},
+		{"v3", "6fa459ea-ee8a-3ca4-894e-db77e160355e", 3, VariantRFC4122},
+		{"v4", "f47ac10b-58cc-4372-a567-0e02b2c3d479", 4, VariantRFC4122},
+		{"v5", "886313e1-3b8a-5372-9b90-0c9aee199e5d", 5, VariantRFC4122},
+		{"nil", "00000000-0000-0000-0000-000000000000", 0, VariantNCS},
+		{"microsoft", "00000000-0000-0000-c000-000000000046", 0, VariantMicrosoft},
+		{"max", "ffffffff-ffff-ffff-ffff-ffffffffffff", 15, VariantFuture},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := mustParseUUID(t, tt.uuid)
+			if got := u.Version(); got != tt.wantVersion {
+				t.Errorf("Version() = %d, want %d", got, tt.wantVersion)
+			}
+			if got := u.Variant(); got != tt.wantVariant {
+				t.Errorf("Variant() = %d, want %d", got, tt.wantVariant)
+			}
+		})
+	}
+}
+
+func TestNewRandomUUID(t *testing.T) {
+	a, err := NewRandomUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewRandomUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []UUID{a, b} {
+		if u.Version() != 4 || u.Variant() != VariantRFC4122 {
+			t.Errorf("%x has version %d and variant %d, want 4 and %d", u, u.Version(), u.Variant(), VariantRFC4122)
+		}
+	}
+	if a == b {
+		t.Errorf("two calls returned the same UUID %x", a)
+	}
+}
@@ -0,0 +1,67 @@
+package shardedmap
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// uuidN returns a UUID whose last byte is n, for readable test keys.
+func uuidN(n byte) UUID {
+	var u UUID
+	u[len(u)-1] = n
+	return u
+}
+
+func TestUUIDMapRangeEarlyReturnReleasesLock(t *testing.T) {
+	const shards = 4
+	sm := NewUUIDMap(shards)
+	for i := 0; i < 64; i++ {
+		sm.Store(uuidN(byte(i+1)), i)
+	}
+	testRangeReleasesLocks(t, shards, sm.ShardSnapshot, sm.Range, sm.Store)
+}
+
+func TestUUIDMapLoadOrStoreFunc(t *testing.T) {
+	testLoadOrStoreFunc(t, uuidN(42), NewUUIDMap(4).LoadOrStoreFunc)
+	testLoadOrStoreFunc(t, uuidN(42), NewUUIDMap(4, WithoutReadFastPath()).LoadOrStoreFunc)
+}
+
+func TestUUIDMapRejectZeroKey(t *testing.T) {
+	var zero UUID
+	sm := NewUUIDMap(4, RejectZeroKey())
+
+	sm.Store(zero, 1)
+	if actual, loaded := sm.LoadOrStore(zero, 1); actual != 1 || loaded {
+		t.Errorf("LoadOrStore = (%v, %v), want (1, false)", actual, loaded)
+	}
+	actual, loaded := sm.LoadOrStoreFunc(zero, func() interface{} { return 2 })
+	if actual != 2 || loaded {
+		t.Errorf("LoadOrStoreFunc = (%v, %v), want (2, false)", actual, loaded)
+	}
+	if sm.StoreGen(zero, 1, sm.Epoch()) {
+		t.Error("StoreGen reported storing the zero key")
+	}
+	value, ok := sm.Update(zero, func(interface{}, bool) (interface{}, bool) {
+		t.Error("Update called f for the zero key")
+		return 1, true
+	})
+	if value != nil || ok {
+		t.Errorf("Update = (%v, %v), want (nil, false)", value, ok)
+	}
+	if _, ok := sm.Load(zero); ok {
+		t.Error("the zero key was stored")
+	}
+
+	// Both would wait forever otherwise.
+	ctx, cancel := context.WithTimeout(context.Background(), lockTimeout)
+	defer cancel()
+	if _, err := sm.WaitLoad(ctx, zero); !errors.Is(err, ErrZeroKey) {
+		t.Errorf("WaitLoad error = %v, want ErrZeroKey", err)
+	}
+	values, unsubscribe := sm.Subscribe(zero)
+	defer unsubscribe()
+	if _, open := <-values; open {
+		t.Error("Subscribe channel for the zero key is open")
+	}
+}
@@ -0,0 +1,148 @@
+package shardedmap
+
+// UUIDSet is a sharded set of UUID, built like UUIDMap but without values.
+type UUIDSet struct {
+	shardCount uint64 // Don't alter after creation, no mutex here
+	mutexes    []shardMutex
+	sets       []map[UUID]struct{}
+	opts       options
+}
+
+// NewUUIDSet ...
+func NewUUIDSet(shardCount int, opts ...Option) *UUIDSet {
+	return newUUIDSet(shardCount, newOptions(opts))
+}
+
+func newUUIDSet(shardCount int, opts options) *UUIDSet {
+	shardCount = opts.shards(shardCount)
+
+	s := &UUIDSet{
+		shardCount: uint64(shardCount),
+		mutexes:    newShardMutexes(shardCount, opts),
+		sets:       make([]map[UUID]struct{}, shardCount),
+		opts:       opts,
+	}
+
+	for i := range s.sets {
+		s.sets[i] = make(map[UUID]struct{})
+	}
+
+	return s
+}
+
+func (s *UUIDSet) pickShard(key UUID) uint64 {
+	if s.opts.uuidShardFunc != nil {
+		return s.opts.uuidShardFunc(key) % s.shardCount
+	}
+	return s.opts.hashBytes(key[:]) % s.shardCount
+}
+
+// Add ...
+func (s *UUIDSet) Add(key UUID) {
+	shard := s.pickShard(key)
+	s.mutexes[shard].Lock()
+	s.sets[shard][key] = struct{}{}
+	s.mutexes[shard].Unlock()
+}
+
+// Has ...
+func (s *UUIDSet) Has(key UUID) bool {
+	shard := s.pickShard(key)
+	s.mutexes[shard].RLock()
+	_, ok := s.sets[shard][key]
+	s.mutexes[shard].RUnlock()
+	return ok
+}
+
+// Remove ...
+func (s *UUIDSet) Remove(key UUID) {
+	shard := s.pickShard(key)
+	s.mutexes[shard].Lock()
+	delete(s.sets[shard], key)
+	s.mutexes[shard].Unlock()
+}
+
+// Len returns the number of keys in the set, adding up the shard sizes under
+// their read lock.
+func (s *UUIDSet) Len() int {
+	var n int
+	for shard := range s.mutexes {
+		s.mutexes[shard].RLock()
+		n += len(s.sets[shard])
+		s.mutexes[shard].RUnlock()
+	}
+	return n
+}
+
+// Range calls f sequentially for each key present in each of the shards in the
+// set. If f returns false, range stops the iteration. It has the same
+// consistency as UUIDMap.Range.
+func (s *UUIDSet) Range(f func(key UUID) bool) {
+	for shard := range s.mutexes {
+		s.mutexes[shard].RLock()
+		for key := range s.sets[shard] {
+			if !f(key) {
+				s.mutexes[shard].RUnlock()
+				return
+			}
+		}
+		s.mutexes[shard].RUnlock()
+	}
+}
+
+// keys returns a copy of the keys in each shard, taken under its read lock.
+func (s *UUIDSet) keys(shard int) []UUID {
+	s.mutexes[shard].RLock()
+	keys := make([]UUID, 0, len(s.sets[shard]))
+	for key := range s.sets[shard] {
+		keys = append(keys, key)
+	}
+	s.mutexes[shard].RUnlock()
+	return keys
+}
+
+// The set operations below never hold locks of both sets at the same time, so
+// that concurrent operations in the opposite direction (a.Union(b) and
+// b.Union(a)) can't deadlock. They return a new set with the shard count and
+// options of s. Like Range, they don't see a consistent snapshot of either set
+// under concurrent writes.
+
+// Union returns a new set with the keys that are in s, other, or both.
+func (s *UUIDSet) Union(other *UUIDSet) *UUIDSet {
+	res := newUUIDSet(int(s.shardCount), s.opts)
+	s.Range(func(key UUID) bool {
+		res.Add(key)
+		return true
+	})
+	other.Range(func(key UUID) bool {
+		res.Add(key)
+		return true
+	})
+	return res
+}
+
+// Intersect returns a new set with the keys that are both in s and other.
+func (s *UUIDSet) Intersect(other *UUIDSet) *UUIDSet {
+	res := newUUIDSet(int(s.shardCount), s.opts)
+	for shard := range s.mutexes {
+		for _, key := range s.keys(shard) {
+			if other.Has(key) {
+				res.Add(key)
+			}
+		}
+	}
+	return res
+}
+
+// Difference returns a new set with the keys that are in s but not in other.
+func (s *UUIDSet) Difference(other *UUIDSet) *UUIDSet {
+	res := newUUIDSet(int(s.shardCount), s.opts)
+	for shard := range s.mutexes {
+		for _, key := range s.keys(shard) {
+			if !other.Has(key) {
+				res.Add(key)
+			}
+		}
+	}
+	return res
+}
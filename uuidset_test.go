@@ -0,0 +1,75 @@
+package shardedmap
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func newUUIDSetOf(shardCount int, keys ...UUID) *UUIDSet {
+	s := NewUUIDSet(shardCount)
+	for _, key := range keys {
+		s.Add(key)
+	}
+	return s
+}
+
+func uuidSetKeys(s *UUIDSet) []UUID {
+	keys := []UUID{}
+	s.Range(func(key UUID) bool {
+		keys = append(keys, key)
+		return true
+	})
+	slices.SortFunc(keys, func(a, b UUID) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	return keys
+}
+
+func TestUUIDSetOperations(t *testing.T) {
+	tests := []struct {
+		name                         string
+		a, b                         []UUID
+		union, intersect, difference []UUID
+	}{
+		{
+			name:       "disjoint",
+			a:          []UUID{uuidN(1), uuidN(2)},
+			b:          []UUID{uuidN(3), uuidN(4)},
+			union:      []UUID{uuidN(1), uuidN(2), uuidN(3), uuidN(4)},
+			intersect:  []UUID{},
+			difference: []UUID{uuidN(1), uuidN(2)},
+		},
+		{
+			name:       "identical",
+			a:          []UUID{uuidN(1), uuidN(2), uuidN(3)},
+			b:          []UUID{uuidN(1), uuidN(2), uuidN(3)},
+			union:      []UUID{uuidN(1), uuidN(2), uuidN(3)},
+			intersect:  []UUID{uuidN(1), uuidN(2), uuidN(3)},
+			difference: []UUID{},
+		},
+		{
+			name:       "partial overlap",
+			a:          []UUID{uuidN(1), uuidN(2), uuidN(3)},
+			b:          []UUID{uuidN(2), uuidN(3), uuidN(4)},
+			union:      []UUID{uuidN(1), uuidN(2), uuidN(3), uuidN(4)},
+			intersect:  []UUID{uuidN(2), uuidN(3)},
+			difference: []UUID{uuidN(1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Different shard counts, so keys don't sit in matching shards.
+			a, b := newUUIDSetOf(4, tt.a...), newUUIDSetOf(3, tt.b...)
+			if got := uuidSetKeys(a.Union(b)); !slices.Equal(got, tt.union) {
+				t.Errorf("Union = %v, want %v", got, tt.union)
+			}
+			if got := uuidSetKeys(a.Intersect(b)); !slices.Equal(got, tt.intersect) {
+				t.Errorf("Intersect = %v, want %v", got, tt.intersect)
+			}
+			if got := uuidSetKeys(a.Difference(b)); !slices.Equal(got, tt.difference) {
+				t.Errorf("Difference = %v, want %v", got, tt.difference)
+			}
+		})
+	}
+}